@@ -0,0 +1,94 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+const benchKeys = 100_000
+
+func benchKey(i int) []byte {
+	return fmt.Appendf(nil, "key_%08d", i)
+}
+
+func newBenchTree(b *testing.B, n int) *Btree {
+	b.Helper()
+	disk := &MockDisk{pages: make(map[uint64][]byte)}
+	t := &Btree{Get: disk.Get, New: disk.New, Del: disk.Del}
+	for i := range n {
+		if err := t.Insert(benchKey(i), benchKey(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return t
+}
+
+func BenchmarkFindKey(b *testing.B) {
+	node := make(BNode, BNODE_PAGE_SIZE)
+	n := uint16(100) // about as many as a leaf of these keys holds
+	node.setHeader(BNODE_LEAF, n)
+	keys := make([][]byte, n)
+	for i := range n {
+		keys[i] = benchKey(int(i))
+		node.appendKV(i, 0, keys[i], keys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		node.findKey(keys[i%int(n)])
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	t := newBenchTree(b, benchKeys)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := t.GetValue(benchKey(rand.IntN(benchKeys))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertSequential(b *testing.B) {
+	t := newBenchTree(b, 0)
+	b.ResetTimer()
+	for i := range b.N {
+		if err := t.Insert(benchKey(i), benchKey(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertRandom(b *testing.B) {
+	t := newBenchTree(b, 0)
+	b.ResetTimer()
+	for range b.N {
+		k := benchKey(rand.IntN(benchKeys))
+		if err := t.Insert(k, k); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	t := newBenchTree(b, b.N)
+	b.ResetTimer()
+	for _, i := range rand.Perm(b.N) {
+		if err := t.Delete(benchKey(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	t := newBenchTree(b, benchKeys)
+	b.ResetTimer()
+	it := t.SeekGE(nil)
+	for range b.N {
+		if !it.Valid() {
+			it = t.SeekGE(nil)
+		}
+		_ = it.Value()
+		it.Next()
+	}
+}
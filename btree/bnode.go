@@ -3,6 +3,7 @@ package btree
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 )
 
 type BNode []byte
@@ -10,20 +11,30 @@ type BNode []byte
 const (
 	BNODE_INTERNAL = iota
 	BNODE_LEAF
+	BNODE_OVERFLOW
 )
 
 const (
-	HEADER_SIZE = 4
+	HEADER_SIZE = 8
 	PTRS_SIZE   = 8
 	OFFSET_SIZE = 2
 	KLEN_SIZE   = 2
 	VLEN_SIZE   = 2
 
 	BNODE_PAGE_SIZE = 4096
+
+	// every page, whatever it holds, keeps a checksum of its content at
+	// the same place in the header. It is written and checked by kv.
+	CHECKSUM_OFFSET = 4
+	CHECKSUM_SIZE   = 4
+
+	// set in the vlen of a value that lives in overflow pages, the leaf
+	// only holds a reference to them
+	VLEN_OVERFLOW = 0x8000
 )
 
-// HEADER | PTRS 		| OFFSETS 		| KV Pairs
-// 4b       nkeys * 8n    nkeys * 2b      2b + 2b + (k+v)b
+// HEADER                    | PTRS         | OFFSETS      | KV Pairs
+// 2b type, 2b nkeys, 4b crc   nkeys * 8b     nkeys * 2b     2b + 2b + (k+v)b
 func (n BNode) setHeader(nodeType uint16, nkeys uint16) {
 	binary.LittleEndian.PutUint16(n[0:], nodeType)
 	binary.LittleEndian.PutUint16(n[2:], nkeys)
@@ -47,24 +58,24 @@ func (n BNode) getPtr(idx uint16) uint64 {
 	return binary.LittleEndian.Uint64(n[pos:])
 }
 
+// offsets are stored from idx 1, each one tells where pair idx starts which
+// is also where pair idx-1 ends. Pair 0 always starts at 0.
 func (n BNode) setOffset(idx uint16, offset uint16) {
-	pos := HEADER_SIZE + PTRS_SIZE*n.getKeys() + OFFSET_SIZE*idx
+	pos := HEADER_SIZE + PTRS_SIZE*n.getKeys() + OFFSET_SIZE*(idx-1)
 	binary.LittleEndian.PutUint16(n[pos:], offset)
 }
 
 func (n BNode) getOffset(idx uint16) uint16 {
-	pos := HEADER_SIZE + PTRS_SIZE*n.getKeys() + OFFSET_SIZE*idx
+	if idx == 0 {
+		return 0
+	}
+	pos := HEADER_SIZE + PTRS_SIZE*n.getKeys() + OFFSET_SIZE*(idx-1)
 	return binary.LittleEndian.Uint16(n[pos:])
 }
 
 func (n BNode) getKVPos(idx uint16) uint16 {
 	pos := HEADER_SIZE + PTRS_SIZE*n.getKeys() + OFFSET_SIZE*n.getKeys()
-	// each offeset tells us where
-	// each idx ends, so idx 0 shows where idx 0 ends which means where idx 1 should start
-	if idx > 0 {
-		pos += n.getOffset(idx - 1)
-	}
-	return pos
+	return pos + n.getOffset(idx)
 }
 
 func (n BNode) getKey(idx uint16) []byte {
@@ -78,7 +89,7 @@ func (n BNode) getVal(idx uint16) []byte {
 	pos := n.getKVPos(idx)
 
 	klen := binary.LittleEndian.Uint16(n[pos:])
-	vlen := binary.LittleEndian.Uint16(n[pos+KLEN_SIZE:])
+	vlen := binary.LittleEndian.Uint16(n[pos+KLEN_SIZE:]) &^ VLEN_OVERFLOW
 	return n[pos+KLEN_SIZE+VLEN_SIZE+uint16(klen):][:vlen]
 }
 
@@ -93,27 +104,36 @@ func (n BNode) appendKV(idx uint16, ptr uint64, k, v []byte) {
 	copy(n[pos+KLEN_SIZE+VLEN_SIZE+uint16(len(k)):], v)
 
 	// offset starts counting from where the offset section ends, so it needs to add the previous offset every time
-	offset := VLEN_SIZE + KLEN_SIZE + len(k) + len(v) + int(n.getOffset(idx-1))
+	offset := VLEN_SIZE + KLEN_SIZE + len(k) + len(v) + int(n.getOffset(idx))
 
-	n.setOffset(idx, uint16(offset))
+	n.setOffset(idx+1, uint16(offset))
 
 }
 
-// TODO: binary search
-func (n BNode) findKey(k []byte) uint16 {
-	var i uint16 = 0
+func (n BNode) isOverflow(idx uint16) bool {
+	pos := n.getKVPos(idx)
+	return binary.LittleEndian.Uint16(n[pos+KLEN_SIZE:])&VLEN_OVERFLOW != 0
+}
 
-	for i = range n.getKeys() {
-		key := n.getKey(uint16(i))
-		cmp := bytes.Compare(key, k)
-		if cmp == 0 {
-			return i
-		}
-		if cmp > 0 {
-			return i - 1
-		}
+// setOverflow flags the value of idx, already appended, as an overflow
+// reference
+func (n BNode) setOverflow(idx uint16) {
+	pos := n.getKVPos(idx)
+	vlen := binary.LittleEndian.Uint16(n[pos+KLEN_SIZE:])
+	binary.LittleEndian.PutUint16(n[pos+KLEN_SIZE:], vlen|VLEN_OVERFLOW)
+}
+
+// findKey returns the position of the last key <= k, or 0 if every key is
+// bigger. The keys are sorted so it binary searches the offset table.
+func (n BNode) findKey(k []byte) uint16 {
+	// the first key > k
+	i := sort.Search(int(n.getKeys()), func(i int) bool {
+		return bytes.Compare(n.getKey(uint16(i)), k) > 0
+	})
+	if i == 0 {
+		return 0
 	}
-	return i
+	return uint16(i - 1)
 }
 
 func copynKV(src BNode, srcIdx uint16, dst BNode, dstIdx uint16, n uint16) {
@@ -122,6 +142,9 @@ func copynKV(src BNode, srcIdx uint16, dst BNode, dstIdx uint16, n uint16) {
 		k := src.getKey(srcIdx + uint16(i))
 		v := src.getVal(srcIdx + uint16(i))
 		dst.appendKV(dstIdx+uint16(i), src.getPtr(srcIdx+uint16(i)), k, v)
+		if src.isOverflow(srcIdx + uint16(i)) {
+			dst.setOverflow(dstIdx + uint16(i))
+		}
 	}
 }
 
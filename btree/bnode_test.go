@@ -123,3 +123,36 @@ func TestSplitNodeTo2(t *testing.T) {
 	}
 
 }
+
+func TestFindKey(t *testing.T) {
+	node := make(BNode, BNODE_PAGE_SIZE)
+	keys := [][]byte{nil, []byte("b"), []byte("d"), []byte("f"), []byte("h")}
+	node.setHeader(BNODE_LEAF, uint16(len(keys)))
+	for i, k := range keys {
+		node.appendKV(uint16(i), 0, k, nil)
+	}
+
+	cases := map[string]uint16{
+		"":  0,
+		"a": 0,
+		"b": 1,
+		"c": 1,
+		"d": 2,
+		"g": 3,
+		"h": 4,
+		"z": 4,
+	}
+	for k, expected := range cases {
+		if idx := node.findKey([]byte(k)); idx != expected {
+			t.Fatalf("findKey(%q): expected %d got %d", k, expected, idx)
+		}
+	}
+
+	// every key is bigger than k
+	node.setHeader(BNODE_LEAF, 2)
+	node.appendKV(0, 0, []byte("m"), nil)
+	node.appendKV(1, 0, []byte("n"), nil)
+	if idx := node.findKey([]byte("a")); idx != 0 {
+		t.Fatalf("expected 0 got %d", idx)
+	}
+}
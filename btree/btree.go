@@ -8,188 +8,500 @@ import (
 
 const (
 	BTREE_MAX_KEY_SIZE = 1024
+	// values up to BTREE_MAX_VAL_SIZE are kept in the leaf, bigger ones in
+	// overflow pages
 	BTREE_MAX_VAL_SIZE = 1024
+	// typed so the bound holds on 32-bit platforms too, where it doesn't
+	// fit in an int
+	BTREE_MAX_OVERFLOW_SIZE uint64 = 1 << 32
 )
 
+var (
+	ErrNotFound    = errors.New("key not found")
+	ErrKeyTooLarge = errors.New("key is too big")
+	// ErrEmptyKey is returned for a zero-length key, which would be taken
+	// for the sentinel every tree starts with.
+	ErrEmptyKey    = errors.New("key is empty")
+	ErrValTooLarge = errors.New("val is too big")
+	ErrCorrupt     = errors.New("corrupt page")
+)
+
+// Btree is a copy-on-write B+tree over pages handed out by the callbacks.
+// Errors returned by the callbacks, e.g. a page that can't be read, are
+// passed back to the caller of the operation.
 type Btree struct {
 	Root uint64
-	Get  func(uint64) []byte
-	New  func([]byte) uint64
-	Del  func(uint64)
+	Get  func(uint64) ([]byte, error)
+	New  func([]byte) (uint64, error)
+	Del  func(uint64) error
+}
+
+func (t *Btree) getNode(ptr uint64) (BNode, error) {
+	data, err := t.Get(ptr)
+	if err != nil {
+		return nil, err
+	}
+	node := BNode(data)
+	if typ := node.getType(); typ != BNODE_LEAF && typ != BNODE_INTERNAL {
+		return nil, fmt.Errorf("%w: page %d has node type %d", ErrCorrupt, ptr, typ)
+	}
+	return node, nil
 }
 
 func (t *Btree) GetValue(k []byte) ([]byte, error) {
-	if t.Root == 0 {
-		return nil, fmt.Errorf("empty tree")
+	v, _, err := t.GetVersioned(k)
+	return v, err
+}
+
+// GetVersioned returns the value of k together with the version it was
+// written with, see UpdateReq.Version.
+func (t *Btree) GetVersioned(k []byte) ([]byte, uint64, error) {
+	leaf, idx, err := t.lookup(k)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := t.leafValue(leaf, idx)
+	return v, leaf.getPtr(idx), err
+}
+
+// Height returns the number of levels of the tree, following its leftmost
+// path since every leaf is at the same depth.
+func (t *Btree) Height() (int, error) {
+	height := 0
+	for ptr := t.Root; ptr != 0; height++ {
+		node, err := t.getNode(ptr)
+		if err != nil {
+			return 0, err
+		}
+		if node.getType() == BNODE_LEAF {
+			return height + 1, nil
+		}
+		ptr = node.getPtr(0)
 	}
+	return height, nil
+}
 
-	val := t.getValue(t.Get(t.Root), k)
-	if len(val) == 0 {
-		return val, fmt.Errorf("key doesnt exist")
+// lookup returns the leaf holding k and its position in it.
+func (t *Btree) lookup(k []byte) (BNode, uint16, error) {
+	for ptr := t.Root; ptr != 0; {
+		node, err := t.getNode(ptr)
+		if err != nil {
+			return nil, 0, err
+		}
+		idx := node.findKey(k)
+		if node.getType() == BNODE_INTERNAL {
+			ptr = node.getPtr(idx)
+			continue
+		}
+		// the empty key at the start of the first leaf is the sentinel,
+		// not a key of the caller's
+		if !bytes.Equal(node.getKey(idx), k) || len(k) == 0 {
+			return nil, 0, ErrNotFound
+		}
+		return node, idx, nil
 	}
-	return val, nil
+	return nil, 0, ErrNotFound
 }
 
-func (t *Btree) getValue(node BNode, k []byte) []byte {
-	ptr := node.findKey(k)
+// modes of an UpdateReq
+const (
+	MODE_UPSERT      = iota // insert the key or replace its value
+	MODE_UPDATE_ONLY        // only replace the value of an existing key
+	MODE_INSERT_ONLY        // only add a key that isn't there
+	MODE_DELETE             // remove the key
+)
 
-	switch node.getType() {
-	case BNODE_LEAF:
-		if !bytes.Equal(node.getKey(ptr), k) {
-			return []byte{} // key doesnt exist
+// UpdateReq is a single change to the tree. Update fills in the outputs.
+type UpdateReq struct {
+	Key     []byte
+	Val     []byte
+	Mode    int
+	Version uint64 // kept with the new value, see GetVersioned
+	// Check, if set, sees the current entry of Key once the mode allows
+	// the change and the write only happens if it returns true. exists is
+	// false for a missing key.
+	Check func(exists bool, old []byte, version uint64) bool
+	// Merge, if set, computes Val from the current value of Key when the
+	// leaf is reached. old is nil for a missing key.
+	Merge func(exists bool, old []byte) ([]byte, error)
+	// Overflow says Val is a reference, made with OverflowRef, to overflow
+	// pages the caller already wrote.
+	Overflow bool
+	// NoOld skips reading back the old value, Old stays nil. Check and
+	// Merge don't see it either.
+	NoOld bool
+
+	Existed    bool   // Key was in the tree
+	Old        []byte // the value Key had, it points into the old page
+	OldVersion uint64 // the version Key had
+	Updated    bool   // the change was allowed and the tree was written
+}
+
+// found records the entry of the leaf req is about and reports whether
+// the change may go ahead.
+func (t *Btree) found(req *UpdateReq, leaf BNode, idx uint16, exists bool) (bool, error) {
+	if exists {
+		req.Existed, req.OldVersion = true, leaf.getPtr(idx)
+	}
+	if exists && !req.NoOld {
+		old, err := t.leafValue(leaf, idx)
+		if err != nil {
+			return false, err
 		}
-		return node.getVal(ptr)
-	case BNODE_INTERNAL:
-		childNode := node.getPtr(ptr)
-		return t.getValue(t.Get(childNode), k)
+		req.Old = old
+	}
+	switch {
+	case exists && req.Mode == MODE_INSERT_ONLY,
+		!exists && (req.Mode == MODE_UPDATE_ONLY || req.Mode == MODE_DELETE):
+		return false, nil
 	}
-	panic("invalid key")
+	return req.Check == nil || req.Check(req.Existed, req.Old, req.OldVersion), nil
 }
-func (t *Btree) Insert(k, v []byte) error {
-	if len(k) > BTREE_MAX_KEY_SIZE {
-		return errors.New("key is too big")
+
+// value returns the value req writes, merged into the old one if asked to
+func (req *UpdateReq) value() ([]byte, error) {
+	if req.Merge == nil {
+		return req.Val, nil
+	}
+	v, err := req.Merge(req.Existed, req.Old)
+	if err != nil {
+		return nil, err
 	}
+	if err := CheckSize(req.Key, v); err != nil {
+		return nil, err
+	}
+	req.Val = v
+	return v, nil
+}
 
-	if len(v) > BTREE_MAX_VAL_SIZE {
-		return errors.New("val is too big")
+// Update applies req in one pass down the tree. A change the mode doesn't
+// allow leaves the tree as it was and Updated false, it isn't an error.
+func (t *Btree) Update(req *UpdateReq) error {
+	req.Existed, req.Old, req.Updated = false, nil, false
+	if len(req.Key) == 0 {
+		return ErrEmptyKey
+	}
+	if req.Mode == MODE_DELETE {
+		return t.delete(req)
+	}
+	if err := CheckSize(req.Key, req.Val); err != nil {
+		return err
 	}
 
 	if t.Root == 0 {
+		if ok, err := t.found(req, nil, 0, false); err != nil || !ok {
+			return err
+		}
+		v, err := req.value()
+		if err != nil {
+			return err
+		}
+		stored, overflow, err := t.storeValue(req, v)
+		if err != nil {
+			return err
+		}
 		new := make(BNode, BNODE_PAGE_SIZE)
 		new.setHeader(BNODE_LEAF, 2)
 		new.appendKV(0, 0, nil, nil)
-		new.appendKV(1, 0, k, v)
-		t.Root = t.New(new)
+		new.appendKV(1, req.Version, req.Key, stored)
+		if overflow {
+			new.setOverflow(1)
+		}
+		root, err := t.New(new)
+		if err != nil {
+			return err
+		}
+		t.Root = root
+		req.Updated = true
 		return nil
 	}
 
-	node := t.insertNode(t.Get(t.Root), k, v)
+	root, err := t.getNode(t.Root)
+	if err != nil {
+		return err
+	}
+	node, err := t.insertNode(root, req)
+	if err != nil || node == nil {
+		return err
+	}
+	req.Updated = true
 
 	nsplit, splitted := splitNode(node)
 
-	t.Del(t.Root)
+	if err := t.Del(t.Root); err != nil {
+		return err
+	}
 	if nsplit == 1 {
-		t.Root = t.New(splitted[0])
-	} else {
-		newRoot := make(BNode, BNODE_PAGE_SIZE)
-		newRoot.setHeader(BNODE_INTERNAL, nsplit)
-		for i := range nsplit {
-			newSplitted := splitted[i]
+		newRoot, err := t.New(splitted[0])
+		if err != nil {
+			return err
+		}
+		t.Root = newRoot
+		return nil
+	}
 
-			newRoot.appendKV(uint16(i), t.New(newSplitted), newSplitted.getKey(0), nil)
+	newRoot := make(BNode, BNODE_PAGE_SIZE)
+	newRoot.setHeader(BNODE_INTERNAL, nsplit)
+	for i := range nsplit {
+		newSplitted := splitted[i]
+		ptr, err := t.New(newSplitted)
+		if err != nil {
+			return err
 		}
-		t.Root = t.New(newRoot)
+		newRoot.appendKV(uint16(i), ptr, newSplitted.getKey(0), nil)
+	}
+	ptr, err := t.New(newRoot)
+	if err != nil {
+		return err
 	}
+	t.Root = ptr
+	return nil
+}
 
+// Insert sets k to v, adding k if it isn't there.
+func (t *Btree) Insert(k, v []byte) error {
+	return t.Update(&UpdateReq{Key: k, Val: v})
+}
+
+// CheckSize reports whether k and v fit in the tree: k must not be empty
+// and both must be small enough for Insert.
+func CheckSize(k, v []byte) error {
+	if len(k) == 0 {
+		return ErrEmptyKey
+	}
+	if len(k) > BTREE_MAX_KEY_SIZE {
+		return ErrKeyTooLarge
+	}
+	if uint64(len(v)) > BTREE_MAX_OVERFLOW_SIZE {
+		return ErrValTooLarge
+	}
 	return nil
 }
 
-func (t *Btree) insertNode(node BNode, k, v []byte) BNode {
+// insertNode returns the updated copy of node, or nil if req leaves the
+// tree unchanged.
+func (t *Btree) insertNode(node BNode, req *UpdateReq) (BNode, error) {
+	k := req.Key
 	idx := node.findKey(k)
 
 	new := make(BNode, 2*BNODE_PAGE_SIZE)
 
 	switch node.getType() {
 	case BNODE_LEAF:
-		if bytes.Equal(node.getKey(idx), k) {
-			leafUpdate(node, new, idx, k, v)
+		found := bytes.Equal(node.getKey(idx), k)
+		if ok, err := t.found(req, node, idx, found); err != nil || !ok {
+			return nil, err
+		}
+		v, err := req.value()
+		if err != nil {
+			return nil, err
+		}
+		stored, overflow, err := t.storeValue(req, v)
+		if err != nil {
+			return nil, err
+		}
+		pos := idx + 1
+		if found {
+			leafUpdate(node, new, idx, k, stored)
+			pos = idx
 		} else {
-			leafInsert(node, new, idx+1, k, v)
+			leafInsert(node, new, pos, k, stored)
+		}
+		// leaves keep the version of each key in the slot of its pointer
+		new.setPtr(pos, req.Version)
+		if overflow {
+			new.setOverflow(pos)
+		}
+		if found && node.isOverflow(idx) {
+			if err := t.freeOverflow(node.getVal(idx)); err != nil {
+				return nil, err
+			}
 		}
 	case BNODE_INTERNAL:
-
 		childPtr := node.getPtr(idx)
-		updated := t.insertNode(t.Get(childPtr), k, v)
-		t.Del(childPtr)
+		child, err := t.getNode(childPtr)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := t.insertNode(child, req)
+		if err != nil || updated == nil {
+			return nil, err
+		}
+		if err := t.Del(childPtr); err != nil {
+			return nil, err
+		}
 
 		nsplit, splitted := splitNode(updated)
 		new.setHeader(BNODE_INTERNAL, node.getKeys()+nsplit-1)
 		copynKV(node, 0, new, 0, idx)
 		for i, newSplitted := range splitted[:nsplit] {
-			new.appendKV(idx+uint16(i), t.New(newSplitted), newSplitted.getKey(0), nil)
+			ptr, err := t.New(newSplitted)
+			if err != nil {
+				return nil, err
+			}
+			new.appendKV(idx+uint16(i), ptr, newSplitted.getKey(0), nil)
 		}
 		copynKV(node, idx+1, new, idx+nsplit, node.getKeys()-idx-1)
 
 	}
-	return new
+	return new, nil
 }
 
+// Delete removes k from the tree, it returns ErrNotFound if k isn't there.
 func (t *Btree) Delete(k []byte) error {
+	req := &UpdateReq{Key: k, Mode: MODE_DELETE}
+	if err := t.Update(req); err != nil {
+		return err
+	}
+	if !req.Existed {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (t *Btree) delete(req *UpdateReq) error {
 	if t.Root == 0 {
-		return fmt.Errorf("key not found")
+		return nil
 	}
 
-	newRoot := t.deleteNode(t.Get(t.Root), k)
+	root, err := t.getNode(t.Root)
+	if err != nil {
+		return err
+	}
+	newRoot, err := t.deleteNode(root, req)
+	if err != nil || newRoot == nil {
+		return err
+	}
+	req.Updated = true
 
-	t.Del(t.Root)
-	t.Root = t.New(newRoot)
+	if err := t.Del(t.Root); err != nil {
+		return err
+	}
+	switch {
+	case newRoot.getKeys() == 0:
+		t.Root = 0
+		return nil
+	case newRoot.getType() == BNODE_INTERNAL && newRoot.getKeys() == 1:
+		// a root with a single child gives its place to it
+		t.Root = newRoot.getPtr(0)
+		return nil
+	}
+	ptr, err := t.New(newRoot)
+	if err != nil {
+		return err
+	}
+	t.Root = ptr
 	return nil
 }
 
-func (t *Btree) deleteNode(node BNode, k []byte) BNode {
-
+// deleteNode returns the updated copy of node, or nil if the key isn't
+// there.
+func (t *Btree) deleteNode(node BNode, req *UpdateReq) (BNode, error) {
+	k := req.Key
 	idx := node.findKey(k)
 
 	new := make(BNode, BNODE_PAGE_SIZE)
 
 	switch node.getType() {
 	case BNODE_LEAF:
-		if !bytes.Equal(k, node.getKey(idx)) {
-			return BNode{} // not exists
+		if ok, err := t.found(req, node, idx, bytes.Equal(k, node.getKey(idx))); err != nil || !ok {
+			return nil, err
+		}
+		if node.isOverflow(idx) {
+			if err := t.freeOverflow(node.getVal(idx)); err != nil {
+				return nil, err
+			}
 		}
 		new.setHeader(BNODE_LEAF, node.getKeys()-1)
 		copynKV(node, 0, new, 0, idx)
 		copynKV(node, idx+1, new, idx, node.getKeys()-idx-1)
 	case BNODE_INTERNAL:
 		childPtr := node.getPtr(idx)
-		child := t.deleteNode(t.Get(childPtr), k) // contains the updated node (keys is removed if exists)
+		childNode, err := t.getNode(childPtr)
+		if err != nil {
+			return nil, err
+		}
+		child, err := t.deleteNode(childNode, req) // contains the updated node (keys is removed if exists)
+		if err != nil || child == nil {
+			return nil, err
+		}
 
-		mergeDirection, sibling := t.shouldMerge(node, child, idx)
+		mergeDirection, sibling, err := t.shouldMerge(node, child, idx)
+		if err != nil {
+			return nil, err
+		}
 
 		switch {
 		case mergeDirection == -1: // merge with left sibling
 			merged := make(BNode, BNODE_PAGE_SIZE)
 			merge2Nodes(sibling, child, merged)
-			t.Del(node.getPtr(idx - 1))
-			replace2Ptrs(new, node, t.New(merged), idx-1, merged.getKey(0))
+			if err := t.Del(node.getPtr(idx - 1)); err != nil {
+				return nil, err
+			}
+			ptr, err := t.New(merged)
+			if err != nil {
+				return nil, err
+			}
+			replace2Ptrs(new, node, ptr, idx-1, merged.getKey(0))
 		case mergeDirection == 1: // merge with right sibling
 			merged := make(BNode, BNODE_PAGE_SIZE)
 			merge2Nodes(child, sibling, merged)
-			t.Del(node.getPtr(idx + 1))
-			replace2Ptrs(new, node, t.New(merged), idx, merged.getKey(0))
+			if err := t.Del(node.getPtr(idx + 1)); err != nil {
+				return nil, err
+			}
+			ptr, err := t.New(merged)
+			if err != nil {
+				return nil, err
+			}
+			replace2Ptrs(new, node, ptr, idx, merged.getKey(0))
 		case mergeDirection == 0 && child.getKeys() == 0:
-			new.setHeader(BNODE_INTERNAL, 0)
+			// no sibling to merge with, drop the empty child
+			new.setHeader(BNODE_INTERNAL, node.getKeys()-1)
+			copynKV(node, 0, new, 0, idx)
+			copynKV(node, idx+1, new, idx, node.getKeys()-idx-1)
 		case mergeDirection == 0 && child.getKeys() > 0:
+			ptr, err := t.New(child)
+			if err != nil {
+				return nil, err
+			}
 			new.setHeader(BNODE_INTERNAL, node.getKeys())
 			copynKV(node, 0, new, 0, idx)
-			new.appendKV(idx, t.New(child), child.getKey(0), nil)
+			new.appendKV(idx, ptr, child.getKey(0), nil)
 			copynKV(node, idx+1, new, idx+1, node.getKeys()-idx-1)
 		}
 
-		t.Del(childPtr)
+		if err := t.Del(childPtr); err != nil {
+			return nil, err
+		}
 
 	}
-	return new
+	return new, nil
 }
 
-func (t *Btree) shouldMerge(parent, updated BNode, idx uint16) (int, BNode) {
+func (t *Btree) shouldMerge(parent, updated BNode, idx uint16) (int, BNode, error) {
 	if updated.getBytes() > BNODE_PAGE_SIZE/4 {
-		return 0, BNode{}
+		return 0, BNode{}, nil
 	}
-	if int(idx)-1 > 0 {
-		leftSibling := BNode(t.Get(parent.getPtr(idx - 1)))
+	if idx > 0 {
+		leftSibling, err := t.getNode(parent.getPtr(idx - 1))
+		if err != nil {
+			return 0, nil, err
+		}
 		if leftSibling.getBytes()+updated.getBytes() <= BNODE_PAGE_SIZE {
-			return -1, leftSibling
+			return -1, leftSibling, nil
 		}
 	}
 
 	if idx+1 < parent.getKeys() {
-		rightSibling := BNode(t.Get(parent.getPtr(idx + 1)))
+		rightSibling, err := t.getNode(parent.getPtr(idx + 1))
+		if err != nil {
+			return 0, nil, err
+		}
 		if rightSibling.getBytes()+updated.getBytes() <= BNODE_PAGE_SIZE {
-			return 1, rightSibling
+			return 1, rightSibling, nil
 		}
 	}
 
-	return 0, BNode{}
+	return 0, BNode{}, nil
 
 }
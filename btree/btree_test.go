@@ -2,6 +2,7 @@ package btree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand/v2"
@@ -12,22 +13,23 @@ type MockDisk struct {
 	pages map[uint64][]byte
 }
 
-func (d *MockDisk) Get(ptr uint64) []byte {
+func (d *MockDisk) Get(ptr uint64) ([]byte, error) {
 	if _, ok := d.pages[ptr]; !ok {
-		panic(ptr)
+		return nil, fmt.Errorf("page %d doesn't exist", ptr)
 	}
-	return d.pages[ptr]
+	return d.pages[ptr], nil
 }
 
-func (d *MockDisk) New(data []byte) uint64 {
+func (d *MockDisk) New(data []byte) (uint64, error) {
 	// ptr := uint64(len(d.pages)) + 1
 	ptr := rand.Uint64()
 	d.pages[ptr] = data
-	return ptr
+	return ptr, nil
 }
 
-func (d *MockDisk) Del(ptr uint64) {
+func (d *MockDisk) Del(ptr uint64) error {
 	delete(d.pages, ptr)
+	return nil
 }
 
 func TestInsert(m *testing.T) {
@@ -67,3 +69,162 @@ func TestInsert(m *testing.T) {
 	}
 
 }
+
+func TestNotFound(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	if _, err := t.GetValue([]byte("k")); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("empty tree: expected ErrNotFound got %v", err)
+	}
+	if err := t.Delete([]byte("k")); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("empty tree: expected ErrNotFound got %v", err)
+	}
+
+	for i := range 1000 {
+		if err := t.Insert(fmt.Appendf(nil, "k_%d", i), nil); err != nil {
+			m.Fatal(err)
+		}
+	}
+	root := t.Root
+	if _, err := t.GetValue([]byte("k_1000")); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if err := t.Delete([]byte("k_1000")); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if t.Root != root {
+		m.Fatal("a failed delete must not change the root")
+	}
+
+	// the sentinel isn't a key
+	if _, err := t.GetValue(nil); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("empty key: expected ErrNotFound got %v", err)
+	}
+
+	// empty values are values too
+	if v, err := t.GetValue([]byte("k_10")); err != nil || len(v) != 0 {
+		m.Fatalf("expected an empty value got %q %v", v, err)
+	}
+
+	if err := t.Insert(make([]byte, BTREE_MAX_KEY_SIZE+1), nil); !errors.Is(err, ErrKeyTooLarge) {
+		m.Fatalf("expected ErrKeyTooLarge got %v", err)
+	}
+	// bigger values than a leaf holds go to overflow pages
+	if err := t.Insert([]byte("k"), make([]byte, BTREE_MAX_VAL_SIZE+1)); err != nil {
+		m.Fatal(err)
+	}
+}
+
+func TestPageError(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	for i := range 1000 {
+		if err := t.Insert(fmt.Appendf(nil, "k_%d", i), []byte("v")); err != nil {
+			m.Fatal(err)
+		}
+	}
+
+	failing := errors.New("disk is gone")
+	t.Get = func(uint64) ([]byte, error) { return nil, failing }
+	if _, err := t.GetValue([]byte("k_1")); !errors.Is(err, failing) {
+		m.Fatalf("expected the page error got %v", err)
+	}
+	if err := t.Insert([]byte("k_1"), []byte("v")); !errors.Is(err, failing) {
+		m.Fatalf("expected the page error got %v", err)
+	}
+	it := t.SeekGE(nil)
+	if it.Valid() || !errors.Is(it.Err(), failing) {
+		m.Fatalf("expected the page error got %v", it.Err())
+	}
+}
+
+func TestUpdateModes(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+
+	req := &UpdateReq{Key: []byte("k"), Val: []byte("1"), Mode: MODE_UPDATE_ONLY}
+	if err := t.Update(req); err != nil || req.Updated || req.Existed {
+		m.Fatalf("update-only of a missing key: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Val: []byte("1"), Mode: MODE_INSERT_ONLY}
+	if err := t.Update(req); err != nil || !req.Updated || req.Existed {
+		m.Fatalf("insert-only of a missing key: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Val: []byte("2"), Mode: MODE_INSERT_ONLY}
+	if err := t.Update(req); err != nil || req.Updated || !req.Existed || string(req.Old) != "1" {
+		m.Fatalf("insert-only of an existing key: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Val: []byte("3"), Mode: MODE_UPSERT}
+	if err := t.Update(req); err != nil || !req.Updated || string(req.Old) != "1" {
+		m.Fatalf("upsert: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Mode: MODE_DELETE}
+	if err := t.Update(req); err != nil || !req.Updated || string(req.Old) != "3" {
+		m.Fatalf("delete: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Mode: MODE_DELETE}
+	if err := t.Update(req); err != nil || req.Updated || req.Existed {
+		m.Fatalf("delete of a missing key: %+v %v", req, err)
+	}
+	if err := t.Delete([]byte("k")); !errors.Is(err, ErrNotFound) {
+		m.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+func TestDeleteAll(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+
+	numOfKeys := 3000
+	for i := range numOfKeys {
+		if err := t.Insert(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			m.Fatal(err)
+		}
+	}
+	for n, i := range rand.Perm(numOfKeys) {
+		if err := t.Delete(fmt.Appendf(nil, "k_%d", i)); err != nil {
+			m.Fatal(i, err)
+		}
+		if n%500 == 0 {
+			if _, err := t.GetValue(fmt.Appendf(nil, "k_%d", i)); !errors.Is(err, ErrNotFound) {
+				m.Fatalf("k_%d is still there: %v", i, err)
+			}
+		}
+	}
+
+	// everything merged back into a root leaf holding the sentinel
+	if len(disk.pages) != 1 {
+		m.Fatalf("expected a single page left got %d", len(disk.pages))
+	}
+	root, err := t.getNode(t.Root)
+	if err != nil {
+		m.Fatal(err)
+	}
+	if root.getType() != BNODE_LEAF || root.getKeys() != 1 {
+		m.Fatalf("expected a leaf root with 1 key got type %d with %d keys", root.getType(), root.getKeys())
+	}
+}
@@ -0,0 +1,110 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrUnsorted = errors.New("keys are not in increasing order")
+
+// Builder builds a tree bottom up from keys added in increasing order. It
+// packs every node full and hands it to New once, left to right, level by
+// level. The tree it was made from is left alone, Finish returns the root
+// of the new one.
+type Builder struct {
+	tree   *Btree
+	levels [][]builderEntry // entries of the node being filled at each level, leaves first
+	sizes  []int            // bytes each of those nodes takes so far
+	last   []byte
+}
+
+type builderEntry struct {
+	key      []byte
+	val      []byte
+	ptr      uint64 // the child, or the version in a leaf
+	overflow bool
+}
+
+func (t *Btree) NewBuilder() *Builder {
+	b := &Builder{tree: t, last: []byte{}}
+	b.levels = append(b.levels, []builderEntry{{}}) // the empty key every tree starts with
+	b.sizes = append(b.sizes, HEADER_SIZE+entrySize(builderEntry{}))
+	return b
+}
+
+func entrySize(e builderEntry) int {
+	return PTRS_SIZE + OFFSET_SIZE + KLEN_SIZE + VLEN_SIZE + len(e.key) + len(e.val)
+}
+
+// Add appends k to the tree with the given version. k must be bigger than
+// every key added before it, or Add returns ErrUnsorted.
+func (b *Builder) Add(k, v []byte, version uint64) error {
+	if bytes.Compare(k, b.last) <= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrUnsorted, k, b.last)
+	}
+	if err := CheckSize(k, v); err != nil {
+		return err
+	}
+	stored, overflow, err := b.tree.storeValue(&UpdateReq{}, v)
+	if err != nil {
+		return err
+	}
+	b.last = bytes.Clone(k)
+	return b.push(0, builderEntry{key: b.last, val: bytes.Clone(stored), ptr: version, overflow: overflow})
+}
+
+func (b *Builder) push(level int, e builderEntry) error {
+	if level == len(b.levels) {
+		b.levels = append(b.levels, nil)
+		b.sizes = append(b.sizes, HEADER_SIZE)
+	}
+	if b.sizes[level]+entrySize(e) > BNODE_PAGE_SIZE {
+		if err := b.flush(level); err != nil {
+			return err
+		}
+	}
+	b.levels[level] = append(b.levels[level], e)
+	b.sizes[level] += entrySize(e)
+	return nil
+}
+
+// flush writes the node being filled at level and adds it to its parent
+func (b *Builder) flush(level int) error {
+	entries := b.levels[level]
+	typ := uint16(BNODE_INTERNAL)
+	if level == 0 {
+		typ = BNODE_LEAF
+	}
+
+	node := make(BNode, BNODE_PAGE_SIZE)
+	node.setHeader(typ, uint16(len(entries)))
+	for i, e := range entries {
+		node.appendKV(uint16(i), e.ptr, e.key, e.val)
+		if e.overflow {
+			node.setOverflow(uint16(i))
+		}
+	}
+	ptr, err := b.tree.New(node)
+	if err != nil {
+		return err
+	}
+
+	first := entries[0].key
+	b.levels[level] = entries[:0]
+	b.sizes[level] = HEADER_SIZE
+	return b.push(level+1, builderEntry{key: first, ptr: ptr})
+}
+
+// Finish writes the nodes still being filled and returns the root of the
+// new tree.
+func (b *Builder) Finish() (uint64, error) {
+	for level := 0; ; level++ {
+		if level > 0 && level == len(b.levels)-1 && len(b.levels[level]) == 1 {
+			return b.levels[level][0].ptr, nil
+		}
+		if err := b.flush(level); err != nil {
+			return 0, err
+		}
+	}
+}
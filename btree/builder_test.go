@@ -0,0 +1,93 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBuilder(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+
+	numOfKeys := 20000
+	value := func(i int) []byte {
+		if i%1000 == 0 {
+			return bigValue(i, 3*OVERFLOW_DATA_SIZE)
+		}
+		return fmt.Appendf(nil, "v_%d", i)
+	}
+	b := t.NewBuilder()
+	for i := range numOfKeys {
+		if err := b.Add(fmt.Appendf(nil, "k_%06d", i), value(i), uint64(i)); err != nil {
+			m.Fatal(err)
+		}
+	}
+	if err := b.Add([]byte("k_000001"), nil, 0); !errors.Is(err, ErrUnsorted) {
+		m.Fatalf("expected ErrUnsorted got %v", err)
+	}
+	root, err := b.Finish()
+	if err != nil {
+		m.Fatal(err)
+	}
+	t.Root = root
+
+	for i := range numOfKeys {
+		v, version, err := t.GetVersioned(fmt.Appendf(nil, "k_%06d", i))
+		if err != nil || !bytes.Equal(v, value(i)) || version != uint64(i) {
+			m.Fatalf("k_%06d: got %d bytes at %d %v", i, len(v), version, err)
+		}
+	}
+	n := 0
+	for it := t.SeekGE(nil); it.Valid(); it.Next() {
+		n++
+	}
+	if n != numOfKeys {
+		m.Fatalf("expected %d keys got %d", numOfKeys, n)
+	}
+
+	// the tree keeps working as usual
+	if err := t.Insert([]byte("k_000100x"), []byte("new")); err != nil {
+		m.Fatal(err)
+	}
+	for i := range numOfKeys / 2 {
+		if err := t.Delete(fmt.Appendf(nil, "k_%06d", i)); err != nil {
+			m.Fatal(err)
+		}
+	}
+	if v, err := t.GetValue([]byte("k_000100x")); err != nil || string(v) != "new" {
+		m.Fatalf("got %s %v", v, err)
+	}
+}
+
+func TestBuilderEmpty(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	root, err := t.NewBuilder().Finish()
+	if err != nil {
+		m.Fatal(err)
+	}
+	t.Root = root
+	if it := t.SeekGE(nil); it.Valid() {
+		m.Fatal("expected an empty tree")
+	}
+	if err := t.Insert([]byte("k"), []byte("v")); err != nil {
+		m.Fatal(err)
+	}
+	if err := t.NewBuilder().Add(nil, nil, 0); !errors.Is(err, ErrUnsorted) {
+		m.Fatalf("the empty key is taken, expected ErrUnsorted got %v", err)
+	}
+}
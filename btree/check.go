@@ -0,0 +1,195 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Problem is something wrong with a page found by Check.
+type Problem struct {
+	Page   uint64
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("page %d: %s", p.Page, p.Reason)
+}
+
+// Check walks the tree and checks every page it reaches: the layout of a
+// node fits the page and every pair its declared lengths, keys are in
+// strictly increasing order within the range the parent gives them, the
+// separators of an internal node are the first keys of its children, all
+// leaves are at the same depth and overflow chains hold exactly the value
+// they are referenced for. visit is called once for every page and report
+// for every problem, it doesn't stop at the first one but skips what hangs
+// under a page it can't make sense of. It returns the number of keys.
+func (t *Btree) Check(visit func(ptr uint64), report func(Problem)) int {
+	c := checker{tree: t, visit: visit, report: report, seen: make(map[uint64]bool), leafDepth: -1}
+	if t.Root == 0 {
+		return 0
+	}
+	c.node(t.Root, nil, nil, 0)
+	return max(c.keys-1, 0) // the sentinel
+}
+
+type checker struct {
+	tree      *Btree
+	visit     func(uint64)
+	report    func(Problem)
+	seen      map[uint64]bool
+	leafDepth int
+	keys      int
+}
+
+func (c *checker) problem(ptr uint64, format string, args ...any) {
+	c.report(Problem{Page: ptr, Reason: fmt.Sprintf(format, args...)})
+}
+
+// page reads ptr the first time it is reached
+func (c *checker) page(ptr uint64) []byte {
+	if c.seen[ptr] {
+		c.problem(ptr, "reached more than once")
+		return nil
+	}
+	c.seen[ptr] = true
+	c.visit(ptr)
+	page, err := c.tree.Get(ptr)
+	if err != nil {
+		c.problem(ptr, "%v", err)
+		return nil
+	}
+	return page
+}
+
+// node checks the node ptr whose keys must be in [lo, hi), a nil hi is
+// unbounded, and whose first key must be first unless it is nil.
+func (c *checker) node(ptr uint64, first, hi []byte, depth int) {
+	page := c.page(ptr)
+	if page == nil {
+		return
+	}
+	node := BNode(page)
+	typ := node.getType()
+	if typ != BNODE_LEAF && typ != BNODE_INTERNAL {
+		c.problem(ptr, "node type %d", typ)
+		return
+	}
+	if !c.layout(ptr, node) {
+		return
+	}
+
+	nkeys := node.getKeys()
+	for i := range nkeys {
+		key := node.getKey(i)
+		switch {
+		case len(key) > BTREE_MAX_KEY_SIZE:
+			c.problem(ptr, "key %d is %d bytes", i, len(key))
+		case i == 0 && first != nil && !bytes.Equal(key, first):
+			c.problem(ptr, "first key %q isn't the separator %q", key, first)
+		case i > 0 && bytes.Compare(node.getKey(i-1), key) >= 0:
+			c.problem(ptr, "key %d %q doesn't come after %q", i, key, node.getKey(i-1))
+		case hi != nil && bytes.Compare(key, hi) >= 0:
+			c.problem(ptr, "key %d %q isn't below the next separator %q", i, key, hi)
+		}
+	}
+
+	if typ == BNODE_INTERNAL {
+		for i := range nkeys {
+			var next []byte
+			if i+1 < nkeys {
+				next = node.getKey(i + 1)
+			} else {
+				next = hi
+			}
+			c.node(node.getPtr(i), node.getKey(i), next, depth+1)
+		}
+		return
+	}
+
+	if c.leafDepth < 0 {
+		c.leafDepth = depth
+	} else if depth != c.leafDepth {
+		c.problem(ptr, "leaf at depth %d, the others are at %d", depth, c.leafDepth)
+	}
+	c.keys += int(nkeys)
+	for i := range nkeys {
+		val := node.getVal(i)
+		switch {
+		case !node.isOverflow(i) && len(val) > BTREE_MAX_VAL_SIZE:
+			c.problem(ptr, "value %d is %d bytes", i, len(val))
+		case node.isOverflow(i):
+			c.overflow(ptr, i, val)
+		}
+	}
+}
+
+// layout reports a node whose pairs can't be read safely.
+func (c *checker) layout(ptr uint64, node BNode) bool {
+	if err := node.checkLayout(); err != nil {
+		c.problem(ptr, "%v", err)
+		return false
+	}
+	return true
+}
+
+// checkLayout checks that the offsets and the lengths of every pair agree
+// and stay within the page.
+func (n BNode) checkLayout() error {
+	nkeys := int(n.getKeys())
+	if nkeys == 0 {
+		return fmt.Errorf("node without keys")
+	}
+	start := HEADER_SIZE + (PTRS_SIZE+OFFSET_SIZE)*nkeys
+	if start > BNODE_PAGE_SIZE {
+		return fmt.Errorf("%d keys don't fit in a page", nkeys)
+	}
+	pos := start
+	for i := range nkeys {
+		if pos+KLEN_SIZE+VLEN_SIZE > BNODE_PAGE_SIZE {
+			return fmt.Errorf("pair %d starts at %d, past the page", i, pos)
+		}
+		klen := int(binary.LittleEndian.Uint16(n[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(n[pos+KLEN_SIZE:]) &^ VLEN_OVERFLOW)
+		pos += KLEN_SIZE + VLEN_SIZE + klen + vlen
+		if pos > BNODE_PAGE_SIZE {
+			return fmt.Errorf("pair %d ends at %d, past the page", i, pos)
+		}
+		if end := start + int(n.getOffset(uint16(i+1))); end != pos {
+			return fmt.Errorf("pair %d ends at %d but the offset says %d", i, pos, end)
+		}
+	}
+	return nil
+}
+
+// overflow checks the chain value idx of leaf refers to.
+func (c *checker) overflow(leaf uint64, idx uint16, ref []byte) {
+	ptr, size, err := overflowRef(ref)
+	if err != nil {
+		c.problem(leaf, "value %d: %v", idx, err)
+		return
+	}
+	if size <= BTREE_MAX_VAL_SIZE || size > BTREE_MAX_OVERFLOW_SIZE {
+		c.problem(leaf, "value %d: overflow value of %d bytes", idx, size)
+		return
+	}
+	for size > 0 {
+		if ptr == 0 {
+			c.problem(leaf, "value %d: overflow chain ends %d bytes early", idx, size)
+			return
+		}
+		page := c.page(ptr)
+		if page == nil {
+			return
+		}
+		if typ := binary.LittleEndian.Uint16(page); typ != BNODE_OVERFLOW {
+			c.problem(ptr, "page type %d in the overflow chain of page %d", typ, leaf)
+			return
+		}
+		ptr = binary.LittleEndian.Uint64(page[HEADER_SIZE:])
+		size -= min(size, OVERFLOW_DATA_SIZE)
+	}
+	if ptr != 0 {
+		c.problem(leaf, "value %d: overflow chain goes on past its length to page %d", idx, ptr)
+	}
+}
@@ -0,0 +1,59 @@
+package btree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheck(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	for i := range 1000 {
+		v := []byte("v")
+		if i%100 == 0 {
+			v = make([]byte, 5000)
+		}
+		if err := t.Insert(fmt.Appendf(nil, "k_%04d", i), v); err != nil {
+			m.Fatal(err)
+		}
+	}
+
+	var problems []Problem
+	report := func(p Problem) { problems = append(problems, p) }
+	pages := 0
+	keys := t.Check(func(uint64) { pages++ }, report)
+	if len(problems) != 0 {
+		m.Fatalf("healthy tree: %v", problems)
+	}
+	if keys != 1000 || pages != len(disk.pages) {
+		m.Fatalf("expected 1000 keys in %d pages got %d in %d", len(disk.pages), keys, pages)
+	}
+
+	// a separator that isn't the first key of its child, same length so
+	// the node can still be read
+	root := BNode(disk.pages[t.Root])
+	copy(root.getKey(1), "k_xxxx")
+	t.Check(func(uint64) {}, report)
+	found := false
+	for _, p := range problems {
+		found = found || strings.Contains(p.Reason, "separator")
+	}
+	if !found {
+		m.Fatalf("expected a separator problem got %v", problems)
+	}
+
+	// offsets that point past the page are reported, not read
+	problems = nil
+	root.setOffset(1, 60000)
+	t.Check(func(uint64) {}, report)
+	if len(problems) != 1 || problems[0].Page != t.Root {
+		m.Fatalf("expected a problem with the root got %v", problems)
+	}
+}
@@ -0,0 +1,195 @@
+package btree
+
+import "bytes"
+
+// BIter is a cursor over the keys of a Btree. It keeps the whole path from
+// the root down to the current leaf so it can step to the neighbouring leaf
+// in either direction by walking back up through the internal nodes.
+//
+// A page that fails to load stops the cursor: Valid turns false and Err
+// returns the error.
+type BIter struct {
+	tree *Btree
+	path []BNode  // nodes from root to leaf
+	pos  []uint16 // position inside each node of path
+	err  error
+}
+
+// SeekLE positions the cursor at the greatest key that is <= k.
+func (t *Btree) SeekLE(k []byte) *BIter {
+	it := &BIter{tree: t}
+	for ptr := t.Root; ptr != 0; {
+		node, err := t.getNode(ptr)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		idx := node.findKey(k)
+		it.path = append(it.path, node)
+		it.pos = append(it.pos, idx)
+
+		ptr = 0
+		if node.getType() == BNODE_INTERNAL {
+			ptr = node.getPtr(idx)
+		}
+	}
+	return it
+}
+
+// SeekGE positions the cursor at the smallest key that is >= k.
+func (t *Btree) SeekGE(k []byte) *BIter {
+	it := t.SeekLE(k)
+	if !it.Valid() || bytes.Compare(it.Key(), k) < 0 {
+		it.Next()
+	}
+	return it
+}
+
+// SeekLast positions the cursor at the greatest key of the tree.
+func (t *Btree) SeekLast() *BIter {
+	it := &BIter{tree: t}
+	for ptr := t.Root; ptr != 0; {
+		node, err := t.getNode(ptr)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		idx := node.getKeys() - 1
+		it.path = append(it.path, node)
+		it.pos = append(it.pos, idx)
+
+		ptr = 0
+		if node.getType() == BNODE_INTERNAL {
+			ptr = node.getPtr(idx)
+		}
+	}
+	return it
+}
+
+// Valid reports whether the cursor points at a key. It is false for an
+// empty tree, past either end, and on the empty sentinel key that every
+// tree starts with.
+func (it *BIter) Valid() bool {
+	if it.err != nil || len(it.path) == 0 {
+		return false
+	}
+	leaf := len(it.path) - 1
+	if it.pos[leaf] >= it.path[leaf].getKeys() {
+		return false
+	}
+	return !it.atSentinel()
+}
+
+// Err returns the error that stopped the cursor, if any.
+func (it *BIter) Err() error {
+	return it.err
+}
+
+func (it *BIter) atSentinel() bool {
+	for _, p := range it.pos {
+		if p != 0 {
+			return false
+		}
+	}
+	return len(it.Key()) == 0
+}
+
+// Key returns the current key. Only call it when Valid is true.
+func (it *BIter) Key() []byte {
+	leaf := len(it.path) - 1
+	return it.path[leaf].getKey(it.pos[leaf])
+}
+
+// Version returns the version the current key was written with. Only call
+// it when Valid is true.
+func (it *BIter) Version() uint64 {
+	leaf := len(it.path) - 1
+	return it.path[leaf].getPtr(it.pos[leaf])
+}
+
+// Value returns the current value, put back together from its overflow
+// pages if it didn't fit in the leaf. Only call it when Valid is true. An
+// overflow page that can't be read stops the cursor and Value returns nil.
+func (it *BIter) Value() []byte {
+	leaf := len(it.path) - 1
+	v, err := it.tree.leafValue(it.path[leaf], it.pos[leaf])
+	if err != nil {
+		it.err = err
+		return nil
+	}
+	return v
+}
+
+// Next moves the cursor to the following key. Moving past the last key
+// leaves the cursor invalid, from where Prev returns to the last key.
+func (it *BIter) Next() {
+	if it.err != nil || len(it.path) == 0 {
+		return
+	}
+	leaf := len(it.path) - 1
+	if it.pos[leaf] >= it.path[leaf].getKeys() {
+		return // already past the end
+	}
+	if !it.next(leaf) && it.err == nil {
+		it.pos[leaf] = it.path[leaf].getKeys()
+	}
+}
+
+// Prev moves the cursor to the preceding key. Moving before the first key
+// leaves the cursor invalid, from where Next returns to the first key.
+func (it *BIter) Prev() {
+	if it.err != nil || len(it.path) == 0 {
+		return
+	}
+	leaf := len(it.path) - 1
+	if it.pos[leaf] >= it.path[leaf].getKeys() {
+		// coming back from past the end
+		it.pos[leaf] = it.path[leaf].getKeys() - 1
+		return
+	}
+	it.prev(leaf)
+}
+
+// next advances the position at level. When the node is exhausted it asks
+// the parent to move to its next child and reloads this level from there.
+func (it *BIter) next(level int) bool {
+	if it.pos[level]+1 < it.path[level].getKeys() {
+		it.pos[level]++
+		return true
+	}
+	if level == 0 || !it.next(level-1) {
+		return false
+	}
+	if !it.load(level) {
+		return false
+	}
+	it.pos[level] = 0
+	return true
+}
+
+func (it *BIter) prev(level int) bool {
+	if it.pos[level] > 0 {
+		it.pos[level]--
+		return true
+	}
+	if level == 0 || !it.prev(level-1) {
+		return false
+	}
+	if !it.load(level) {
+		return false
+	}
+	it.pos[level] = it.path[level].getKeys() - 1
+	return true
+}
+
+// load reads the node at level from the pointer its parent is on
+func (it *BIter) load(level int) bool {
+	parent := it.path[level-1]
+	node, err := it.tree.getNode(parent.getPtr(it.pos[level-1]))
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.path[level] = node
+	return true
+}
@@ -0,0 +1,105 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func newTestTree(numOfKeys int) (*Btree, [][]byte) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := &Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	keys := make([][]byte, 0, numOfKeys)
+	for i := range numOfKeys {
+		k := fmt.Appendf(nil, "k_%05d", i)
+		if err := t.Insert(k, fmt.Appendf(nil, "v_%05d", i)); err != nil {
+			panic(err)
+		}
+		keys = append(keys, k)
+	}
+	return t, keys
+}
+
+func TestIterForward(m *testing.T) {
+	t, keys := newTestTree(2000)
+
+	i := 0
+	for it := t.SeekGE(nil); it.Valid(); it.Next() {
+		if !bytes.Equal(it.Key(), keys[i]) {
+			m.Fatalf("expected %s got %s", keys[i], it.Key())
+		}
+		expectedV := fmt.Appendf(nil, "v_%05d", i)
+		if !bytes.Equal(it.Value(), expectedV) {
+			m.Fatalf("expected %s got %s", expectedV, it.Value())
+		}
+		i++
+	}
+	if i != len(keys) {
+		m.Fatalf("expected %d keys got %d", len(keys), i)
+	}
+}
+
+func TestIterBackward(m *testing.T) {
+	t, keys := newTestTree(2000)
+
+	i := len(keys) - 1
+	for it := t.SeekLast(); it.Valid(); it.Prev() {
+		if !bytes.Equal(it.Key(), keys[i]) {
+			m.Fatalf("expected %s got %s", keys[i], it.Key())
+		}
+		i--
+	}
+	if i != -1 {
+		m.Fatalf("stopped at %d", i)
+	}
+}
+
+func TestIterSeek(m *testing.T) {
+	t, _ := newTestTree(1000)
+
+	it := t.SeekGE([]byte("k_00500"))
+	if !it.Valid() || string(it.Key()) != "k_00500" {
+		m.Fatalf("SeekGE exact: got %s", it.Key())
+	}
+
+	it = t.SeekGE([]byte("k_00500x"))
+	if !it.Valid() || string(it.Key()) != "k_00501" {
+		m.Fatalf("SeekGE between: got %s", it.Key())
+	}
+
+	it = t.SeekLE([]byte("k_00500x"))
+	if !it.Valid() || string(it.Key()) != "k_00500" {
+		m.Fatalf("SeekLE between: got %s", it.Key())
+	}
+
+	it = t.SeekLE([]byte("a"))
+	if it.Valid() {
+		m.Fatalf("SeekLE before first key should be invalid, got %s", it.Key())
+	}
+	it.Next()
+	if !it.Valid() || string(it.Key()) != "k_00000" {
+		m.Fatalf("Next from before the first key: got %s", it.Key())
+	}
+
+	it = t.SeekGE([]byte("z"))
+	if it.Valid() {
+		m.Fatalf("SeekGE after last key should be invalid, got %s", it.Key())
+	}
+	it.Prev()
+	if !it.Valid() || string(it.Key()) != "k_00999" {
+		m.Fatalf("Prev from past the end: got %s", it.Key())
+	}
+}
+
+func TestIterEmpty(m *testing.T) {
+	t, _ := newTestTree(0)
+	if t.SeekGE(nil).Valid() || t.SeekLast().Valid() {
+		m.Fatal("empty tree should have no keys")
+	}
+}
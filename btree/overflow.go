@@ -0,0 +1,249 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A value bigger than BTREE_MAX_VAL_SIZE is cut into a chain of overflow
+// pages and the leaf keeps a reference to the chain instead.
+//
+// HEADER | NEXT | DATA                     REFERENCE: FIRST PAGE | LENGTH
+// 8b       8b     OVERFLOW_DATA_SIZE                  8b           8b
+const (
+	OVERFLOW_HEADER_SIZE = HEADER_SIZE + PTRS_SIZE
+	OVERFLOW_DATA_SIZE   = BNODE_PAGE_SIZE - OVERFLOW_HEADER_SIZE
+	OVERFLOW_REF_SIZE    = 16
+	// OVERFLOW_PREALLOC is how much of a value is allocated before its
+	// pages are read
+	OVERFLOW_PREALLOC = 16 * OVERFLOW_DATA_SIZE
+)
+
+// storeValue returns what the leaf keeps for the value of req, the value
+// itself or a reference to the overflow pages it was written to.
+func (t *Btree) storeValue(req *UpdateReq, v []byte) ([]byte, bool, error) {
+	if req.Overflow {
+		return v, true, nil
+	}
+	if len(v) <= BTREE_MAX_VAL_SIZE {
+		return v, false, nil
+	}
+
+	// written from the end so every page knows the next one
+	var next uint64
+	for end := len(v); end > 0; {
+		start := (end - 1) / OVERFLOW_DATA_SIZE * OVERFLOW_DATA_SIZE
+		ptr, err := t.New(OverflowPage(next, v[start:end]))
+		if err != nil {
+			return nil, false, err
+		}
+		next, end = ptr, start
+	}
+	return OverflowRef(next, uint64(len(v))), true, nil
+}
+
+// OverflowPage returns an overflow page holding data, at most
+// OVERFLOW_DATA_SIZE bytes, that links to the page next.
+func OverflowPage(next uint64, data []byte) []byte {
+	page := make([]byte, BNODE_PAGE_SIZE)
+	binary.LittleEndian.PutUint16(page, BNODE_OVERFLOW)
+	binary.LittleEndian.PutUint64(page[HEADER_SIZE:], next)
+	copy(page[OVERFLOW_HEADER_SIZE:], data)
+	return page
+}
+
+// OverflowRef returns the reference a leaf keeps to a size bytes value
+// whose chain starts at first.
+func OverflowRef(first, size uint64) []byte {
+	ref := make([]byte, OVERFLOW_REF_SIZE)
+	binary.LittleEndian.PutUint64(ref, first)
+	binary.LittleEndian.PutUint64(ref[8:], size)
+	return ref
+}
+
+// leafValue returns the value of entry idx of leaf, put back together
+// from its overflow pages if needed.
+func (t *Btree) leafValue(leaf BNode, idx uint16) ([]byte, error) {
+	v := leaf.getVal(idx)
+	if !leaf.isOverflow(idx) {
+		return v, nil
+	}
+	first, size, err := overflowRef(v)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(math.MaxInt) {
+		return nil, fmt.Errorf("%w: %d bytes don't fit in memory", ErrValTooLarge, size)
+	}
+	// sized up front only as far as a few pages go, the buffer grows with
+	// what the chain really holds so a bad length can't make for a huge
+	// allocation
+	out := make([]byte, 0, min(size, OVERFLOW_PREALLOC))
+	err = t.walkOverflow(first, size, func(_ uint64, data []byte) error {
+		out = append(out, data...)
+		return nil
+	})
+	return out, err
+}
+
+// freeOverflow gives back every page of the chain a leaf value refers to
+func (t *Btree) freeOverflow(ref []byte) error {
+	first, size, err := overflowRef(ref)
+	if err != nil {
+		return err
+	}
+	return t.walkOverflow(first, size, func(ptr uint64, _ []byte) error {
+		return t.Del(ptr)
+	})
+}
+
+// walkOverflow calls fn with each page of the chain of a size bytes value
+// and the part of the value it holds.
+func (t *Btree) walkOverflow(ptr, size uint64, fn func(uint64, []byte) error) error {
+	for size > 0 {
+		next, data, err := t.overflowPage(ptr)
+		if err != nil {
+			return err
+		}
+		n := min(size, OVERFLOW_DATA_SIZE)
+		if err := fn(ptr, data[:n]); err != nil {
+			return err
+		}
+		ptr, size = next, size-n
+	}
+	return nil
+}
+
+// overflowPage reads the overflow page ptr, it returns the page it links
+// to and its data.
+func (t *Btree) overflowPage(ptr uint64) (uint64, []byte, error) {
+	if ptr == 0 {
+		return 0, nil, fmt.Errorf("%w: overflow chain ends early", ErrCorrupt)
+	}
+	page, err := t.Get(ptr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typ := binary.LittleEndian.Uint16(page); typ != BNODE_OVERFLOW {
+		return 0, nil, fmt.Errorf("%w: page %d has type %d, expected an overflow page", ErrCorrupt, ptr, typ)
+	}
+	return binary.LittleEndian.Uint64(page[HEADER_SIZE:]), page[OVERFLOW_HEADER_SIZE:], nil
+}
+
+// overflowRef parses a reference made by OverflowRef, a length no value
+// can have is corrupt.
+func overflowRef(ref []byte) (uint64, uint64, error) {
+	if len(ref) != OVERFLOW_REF_SIZE {
+		return 0, 0, fmt.Errorf("%w: overflow reference of %d bytes", ErrCorrupt, len(ref))
+	}
+	first, size := binary.LittleEndian.Uint64(ref), binary.LittleEndian.Uint64(ref[8:])
+	if size > BTREE_MAX_OVERFLOW_SIZE {
+		return 0, 0, fmt.Errorf("%w: overflow value of %d bytes", ErrCorrupt, size)
+	}
+	return first, size, nil
+}
+
+// ValueReader reads a value where it is stored, one overflow page at a
+// time, so a big value never has to be in memory whole. It implements
+// io.ReadSeeker.
+type ValueReader struct {
+	tree     *Btree
+	inline   []byte // the value when it is kept in the leaf
+	overflow bool
+	first    uint64
+	size     uint64
+	off      uint64
+	version  uint64
+
+	// the page read last
+	page     []byte
+	pageIdx  uint64
+	pageNext uint64
+}
+
+// NewValueReader returns a reader over the value of k. It reads the pages
+// as it goes, they must stay valid until it's done.
+func (t *Btree) NewValueReader(k []byte) (*ValueReader, error) {
+	leaf, idx, err := t.lookup(k)
+	if err != nil {
+		return nil, err
+	}
+	r := &ValueReader{tree: t, version: leaf.getPtr(idx)}
+	if !leaf.isOverflow(idx) {
+		r.inline = leaf.getVal(idx)
+		r.size = uint64(len(r.inline))
+		return r, nil
+	}
+	r.overflow = true
+	r.first, r.size, err = overflowRef(leaf.getVal(idx))
+	return r, err
+}
+
+// Size returns the length of the value.
+func (r *ValueReader) Size() int64 {
+	return int64(r.size)
+}
+
+// Version returns the version the value was written with.
+func (r *ValueReader) Version() uint64 {
+	return r.version
+}
+
+func (r *ValueReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if !r.overflow {
+		n := copy(p, r.inline[r.off:])
+		r.off += uint64(n)
+		return n, nil
+	}
+
+	idx := r.off / OVERFLOW_DATA_SIZE
+	if err := r.loadPage(idx); err != nil {
+		return 0, err
+	}
+	end := min(OVERFLOW_DATA_SIZE, r.size-idx*OVERFLOW_DATA_SIZE)
+	n := copy(p, r.page[r.off%OVERFLOW_DATA_SIZE:end])
+	r.off += uint64(n)
+	return n, nil
+}
+
+// loadPage reads page idx of the chain, walking on from the page read
+// last or from the start when going back.
+func (r *ValueReader) loadPage(idx uint64) error {
+	if r.page == nil || idx < r.pageIdx {
+		next, data, err := r.tree.overflowPage(r.first)
+		if err != nil {
+			return err
+		}
+		r.page, r.pageIdx, r.pageNext = data, 0, next
+	}
+	for r.pageIdx < idx {
+		next, data, err := r.tree.overflowPage(r.pageNext)
+		if err != nil {
+			return err
+		}
+		r.page, r.pageIdx, r.pageNext = data, r.pageIdx+1, next
+	}
+	return nil
+}
+
+func (r *ValueReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(r.off)
+	case io.SeekEnd:
+		offset += int64(r.size)
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative position %d", offset)
+	}
+	r.off = uint64(offset)
+	return offset, nil
+}
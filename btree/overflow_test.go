@@ -0,0 +1,107 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+)
+
+func bigValue(i, size int) []byte {
+	return bytes.Repeat(fmt.Appendf(nil, "%d|", i), size/2+1)[:size]
+}
+
+func TestOverflow(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+
+	sizes := []int{BTREE_MAX_VAL_SIZE + 1, OVERFLOW_DATA_SIZE, OVERFLOW_DATA_SIZE + 1, 5*OVERFLOW_DATA_SIZE + 7, 100_000}
+	for i := range 100 {
+		v := bigValue(i, sizes[i%len(sizes)])
+		if err := t.Insert(fmt.Appendf(nil, "k_%03d", i), v); err != nil {
+			m.Fatal(err)
+		}
+	}
+
+	for i := range 100 {
+		v, err := t.GetValue(fmt.Appendf(nil, "k_%03d", i))
+		if err != nil || !bytes.Equal(v, bigValue(i, sizes[i%len(sizes)])) {
+			m.Fatalf("k_%03d: got %d bytes %v", i, len(v), err)
+		}
+	}
+
+	it := t.SeekGE(nil)
+	for i := 0; it.Valid(); i++ {
+		if !bytes.Equal(it.Value(), bigValue(i, sizes[i%len(sizes)])) {
+			m.Fatalf("iterator value of %s is wrong", it.Key())
+		}
+		it.Next()
+	}
+	if it.Err() != nil {
+		m.Fatal(it.Err())
+	}
+
+	// replacing and deleting the values gives their pages back
+	for i := range 50 {
+		if err := t.Insert(fmt.Appendf(nil, "k_%03d", i), []byte("small")); err != nil {
+			m.Fatal(err)
+		}
+	}
+	for i := 50; i < 100; i++ {
+		if err := t.Delete(fmt.Appendf(nil, "k_%03d", i)); err != nil {
+			m.Fatal(err)
+		}
+	}
+	for i := range 50 {
+		if err := t.Delete(fmt.Appendf(nil, "k_%03d", i)); err != nil {
+			m.Fatal(err)
+		}
+	}
+	if len(disk.pages) != 1 {
+		m.Fatalf("expected a single page left got %d", len(disk.pages))
+	}
+}
+
+// TestOverflowBadLength makes sure a reference whose length the chain
+// doesn't hold is reported before the value is allocated.
+func TestOverflowBadLength(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	if err := t.Insert([]byte("k"), bigValue(1, 3*OVERFLOW_DATA_SIZE)); err != nil {
+		m.Fatal(err)
+	}
+	leaf, idx, err := t.lookup([]byte("k"))
+	if err != nil {
+		m.Fatal(err)
+	}
+	ref := leaf.getVal(idx)
+
+	for _, size := range []uint64{1 << 31, BTREE_MAX_OVERFLOW_SIZE + 1, math.MaxUint64} {
+		binary.LittleEndian.PutUint64(ref[8:], size)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := t.GetValue([]byte("k"))
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrCorrupt) {
+			m.Fatalf("length %d: expected ErrCorrupt got %v", size, err)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			m.Fatalf("length %d: allocated %d bytes", size, n)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package btree
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Walk calls fn for every page reachable from the root together with the
+// page pointing to it, 0 for the root. Parents come before their children
+// and the pages of an overflow chain come in order after the leaf holding
+// the reference, each one pointed to by the page before it.
+func (t *Btree) Walk(fn func(ptr, parent uint64) error) error {
+	if t.Root == 0 {
+		return nil
+	}
+	return t.walk(t.Root, 0, fn)
+}
+
+func (t *Btree) walk(ptr, parent uint64, fn func(uint64, uint64) error) error {
+	node, err := t.getNode(ptr)
+	if err != nil {
+		return err
+	}
+	if err := fn(ptr, parent); err != nil {
+		return err
+	}
+	for i := range node.getKeys() {
+		if node.getType() == BNODE_INTERNAL {
+			if err := t.walk(node.getPtr(i), ptr, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if !node.isOverflow(i) {
+			continue
+		}
+		first, size, err := overflowRef(node.getVal(i))
+		if err != nil {
+			return err
+		}
+		prev := ptr
+		err = t.walkOverflow(first, size, func(page uint64, _ []byte) error {
+			err := fn(page, prev)
+			prev = page
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Relocate copies every page of the tree that move returns true for to a
+// new page from New and frees the old one with Del. As with any update the
+// pages on the path to it are copied too, for an overflow page that is the
+// leaf and the pages before it in the chain. Keys keep their versions.
+func (t *Btree) Relocate(move func(ptr uint64) bool) error {
+	if t.Root == 0 {
+		return nil
+	}
+	root, err := t.relocate(t.Root, move)
+	if err != nil {
+		return err
+	}
+	t.Root = root
+	return nil
+}
+
+// relocate returns where the node ptr ended up, ptr if it stayed
+func (t *Btree) relocate(ptr uint64, move func(uint64) bool) (uint64, error) {
+	node, err := t.getNode(ptr)
+	if err != nil {
+		return 0, err
+	}
+	var copied BNode
+	for i := range node.getKeys() {
+		if node.getType() == BNODE_INTERNAL {
+			child := node.getPtr(i)
+			moved, err := t.relocate(child, move)
+			if err != nil {
+				return 0, err
+			}
+			if moved != child {
+				if copied == nil {
+					copied = BNode(slices.Clone(node))
+				}
+				copied.setPtr(i, moved)
+			}
+			continue
+		}
+		if !node.isOverflow(i) {
+			continue
+		}
+		first, size, err := overflowRef(node.getVal(i))
+		if err != nil {
+			return 0, err
+		}
+		moved, err := t.relocateOverflow(first, size, move)
+		if err != nil {
+			return 0, err
+		}
+		if moved != first {
+			if copied == nil {
+				copied = BNode(slices.Clone(node))
+			}
+			copy(copied.getVal(i), OverflowRef(moved, size)) // same length
+		}
+	}
+	if copied == nil {
+		if !move(ptr) {
+			return ptr, nil
+		}
+		copied = BNode(slices.Clone(node))
+	}
+	return t.replace(ptr, copied)
+}
+
+// relocateOverflow returns where the chain of a size bytes value starting
+// at first ended up, first if no page of it moved.
+func (t *Btree) relocateOverflow(first, size uint64, move func(uint64) bool) (uint64, error) {
+	var pages []uint64
+	last := -1
+	err := t.walkOverflow(first, size, func(ptr uint64, _ []byte) error {
+		if move(ptr) {
+			last = len(pages)
+		}
+		pages = append(pages, ptr)
+		return nil
+	})
+	if err != nil || last < 0 {
+		return first, err
+	}
+
+	// copied from the last page that moves back to the start, every page
+	// before it points to the copy of the next one
+	next := uint64(0)
+	for i := last; i >= 0; i-- {
+		page, err := t.Get(pages[i])
+		if err != nil {
+			return 0, err
+		}
+		page = slices.Clone(page)
+		if i < last {
+			binary.LittleEndian.PutUint64(page[HEADER_SIZE:], next)
+		}
+		if next, err = t.replace(pages[i], page); err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// replace writes page to a new page in place of the page ptr
+func (t *Btree) replace(ptr uint64, page []byte) (uint64, error) {
+	moved, err := t.New(page)
+	if err != nil {
+		return 0, err
+	}
+	return moved, t.Del(ptr)
+}
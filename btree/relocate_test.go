@@ -0,0 +1,75 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRelocate(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	for i := range 2000 {
+		v := fmt.Appendf(nil, "v_%d", i)
+		if i%100 == 0 {
+			v = bytes.Repeat(v, 2000)
+		}
+		if err := t.Insert(fmt.Appendf(nil, "k_%d", i), v); err != nil {
+			m.Fatal(err)
+		}
+	}
+
+	count := func() int {
+		n := 0
+		err := t.Walk(func(ptr, parent uint64) error {
+			if _, ok := disk.pages[ptr]; !ok {
+				return fmt.Errorf("page %d doesn't exist", ptr)
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			m.Fatal(err)
+		}
+		return n
+	}
+	if n := count(); n != len(disk.pages) {
+		m.Fatalf("walked %d pages of %d", n, len(disk.pages))
+	}
+
+	// move away the even pages, the new ones may be even again
+	moved := make(map[uint64]bool)
+	for ptr := range disk.pages {
+		moved[ptr] = ptr%2 == 0
+	}
+	if err := t.Relocate(func(ptr uint64) bool { return moved[ptr] }); err != nil {
+		m.Fatal(err)
+	}
+	if n := count(); n != len(disk.pages) {
+		m.Fatalf("walked %d pages of %d", n, len(disk.pages))
+	}
+	err := t.Walk(func(ptr, _ uint64) error {
+		if moved[ptr] {
+			return fmt.Errorf("page %d should have moved", ptr)
+		}
+		return nil
+	})
+	if err != nil {
+		m.Fatal(err)
+	}
+	for i := range 2000 {
+		expected := fmt.Appendf(nil, "v_%d", i)
+		if i%100 == 0 {
+			expected = bytes.Repeat(expected, 2000)
+		}
+		if v, err := t.GetValue(fmt.Appendf(nil, "k_%d", i)); err != nil || !bytes.Equal(v, expected) {
+			m.Fatalf("k_%d: %d bytes %v", i, len(v), err)
+		}
+	}
+}
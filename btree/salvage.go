@@ -0,0 +1,76 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// LeafPair is a pair read straight from a leaf page by ReadLeaf.
+type LeafPair struct {
+	Key     []byte
+	Val     []byte // the reference to the overflow pages if Overflow is set
+	Version uint64
+	// Overflow is set if the value is stored in overflow pages, see
+	// ReadOverflow.
+	Overflow bool
+}
+
+// ReadLeaf returns the pairs of page if it looks like a leaf that can be
+// trusted on its own: the layout of the node fits the page, keys come in
+// strictly increasing order and nothing is bigger than it could be. It is
+// meant for pages found outside of a tree, e.g. to salvage a damaged file.
+// The pairs point into page.
+func ReadLeaf(page []byte) ([]LeafPair, error) {
+	if len(page) != BNODE_PAGE_SIZE {
+		return nil, fmt.Errorf("%w: page of %d bytes", ErrCorrupt, len(page))
+	}
+	node := BNode(page)
+	if typ := node.getType(); typ != BNODE_LEAF {
+		return nil, fmt.Errorf("%w: node type %d isn't a leaf", ErrCorrupt, typ)
+	}
+	if err := node.checkLayout(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	pairs := make([]LeafPair, node.getKeys())
+	for i := range node.getKeys() {
+		p := LeafPair{
+			Key:      node.getKey(i),
+			Val:      node.getVal(i),
+			Version:  node.getPtr(i),
+			Overflow: node.isOverflow(i),
+		}
+		switch {
+		case len(p.Key) > BTREE_MAX_KEY_SIZE:
+			return nil, fmt.Errorf("%w: key %d is %d bytes", ErrCorrupt, i, len(p.Key))
+		case i > 0 && bytes.Compare(pairs[i-1].Key, p.Key) >= 0:
+			return nil, fmt.Errorf("%w: key %d is out of order", ErrCorrupt, i)
+		case p.Overflow && len(p.Val) != OVERFLOW_REF_SIZE:
+			return nil, fmt.Errorf("%w: overflow reference of %d bytes", ErrCorrupt, len(p.Val))
+		case !p.Overflow && len(p.Val) > BTREE_MAX_VAL_SIZE:
+			return nil, fmt.Errorf("%w: value %d is %d bytes", ErrCorrupt, i, len(p.Val))
+		}
+		pairs[i] = p
+	}
+	return pairs, nil
+}
+
+// ReadOverflow puts back together the value whose overflow reference is
+// ref, reading its pages with get. A chain that loops only stops at the
+// length of the value, get can cut it short by failing.
+func ReadOverflow(get func(uint64) ([]byte, error), ref []byte) ([]byte, error) {
+	first, size, err := overflowRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if size > BTREE_MAX_OVERFLOW_SIZE {
+		return nil, fmt.Errorf("%w: overflow value of %d bytes", ErrCorrupt, size)
+	}
+	t := Btree{Get: get}
+	var out []byte // not sized up front, size may be garbage
+	err = t.walkOverflow(first, size, func(_ uint64, data []byte) error {
+		out = append(out, data...)
+		return nil
+	})
+	return out, err
+}
@@ -0,0 +1,66 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestReadLeaf(m *testing.T) {
+	disk := MockDisk{
+		pages: make(map[uint64][]byte),
+	}
+	t := Btree{
+		Get: disk.Get,
+		New: disk.New,
+		Del: disk.Del,
+	}
+	for i := range 1000 {
+		v := fmt.Appendf(nil, "v_%d", i)
+		if i == 500 {
+			v = bytes.Repeat(v, 1000)
+		}
+		if err := t.Insert(fmt.Appendf(nil, "k_%04d", i), v); err != nil {
+			m.Fatal(err)
+		}
+	}
+
+	keys, leaves := 0, 0
+	for _, page := range disk.pages {
+		pairs, err := ReadLeaf(page)
+		if BNode(page).getType() != BNODE_LEAF {
+			if err == nil {
+				m.Fatal("only leaves can be read")
+			}
+			continue
+		}
+		if err != nil {
+			m.Fatal(err)
+		}
+		leaves++
+		for _, p := range pairs {
+			if len(p.Key) == 0 {
+				continue
+			}
+			keys++
+			v := p.Val
+			if p.Overflow {
+				if v, err = ReadOverflow(disk.Get, p.Val); err != nil {
+					m.Fatal(err)
+				}
+			}
+			if expected, _ := t.GetValue(p.Key); !bytes.Equal(v, expected) {
+				m.Fatalf("%s: wrong value of %d bytes", p.Key, len(v))
+			}
+		}
+	}
+	if keys != 1000 || leaves < 2 {
+		m.Fatalf("expected 1000 keys in many leaves got %d in %d", keys, leaves)
+	}
+
+	page := make([]byte, BNODE_PAGE_SIZE)
+	BNode(page).setHeader(BNODE_LEAF, 3)
+	if _, err := ReadLeaf(page); err == nil {
+		m.Fatal("expected an error for a leaf with a broken offset table")
+	}
+}
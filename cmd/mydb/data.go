@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+func runGet(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	o.keysFlag(fs, encRaw)
+	o.valuesFlag(fs, encRaw)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: mydb get [flags] <db> <key>")
+	}
+	k, err := o.keys.decode(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+
+	db, err := kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	v, version, err := db.GetVersion(k)
+	if err != nil {
+		return err
+	}
+	return o.print(stdout, o.record(k, v, version), o.values.encode(v))
+}
+
+// runPut reads the value from stdin, as is, when it isn't given.
+func runPut(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	var o output
+	o.keysFlag(fs, encRaw)
+	o.valuesFlag(fs, encRaw)
+	fs.Parse(args)
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		return fmt.Errorf("usage: mydb put [flags] <db> <key> [value]")
+	}
+	k, err := o.keys.decode(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+	var v []byte
+	if fs.NArg() == 3 {
+		v, err = o.values.decode(fs.Arg(2))
+	} else {
+		v, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("value: %w", err)
+	}
+
+	db, err := kv.Open(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	return errors.Join(db.Insert(k, v), db.Close())
+}
+
+func runDelete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	var o output
+	o.keysFlag(fs, encRaw)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: mydb delete [flags] <db> <key>")
+	}
+	k, err := o.keys.decode(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+
+	db, err := openExisting(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	return errors.Join(db.Delete(k), db.Close())
+}
+
+func runScan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	o.keysFlag(fs, encRaw)
+	o.valuesFlag(fs, encRaw)
+	prefix := fs.String("prefix", "", "only the keys starting with `prefix`")
+	start := fs.String("start", "", "first `key` of the range")
+	end := fs.String("end", "", "`key` ending the range, excluded")
+	reverse := fs.Bool("reverse", false, "in descending key order")
+	limit := fs.Int("limit", 0, "print at most `n` pairs, 0 for all of them")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb scan [flags] <db>")
+	}
+	if *prefix != "" && (*start != "" || *end != "") {
+		return fmt.Errorf("-prefix can't be used with -start or -end")
+	}
+	var bounds [3][]byte // prefix, start and end, nil when not set
+	for i, s := range []string{*prefix, *start, *end} {
+		if s == "" {
+			continue
+		}
+		b, err := o.keys.decode(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", []string{"prefix", "start", "end"}[i], err)
+		}
+		bounds[i] = b
+	}
+
+	db, err := kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var it *kv.Iterator
+	switch {
+	case bounds[0] != nil && *reverse:
+		it = db.ScanPrefixReverse(bounds[0])
+	case bounds[0] != nil:
+		it = db.ScanPrefix(bounds[0])
+	case *reverse:
+		it = db.ScanReverse(bounds[1], bounds[2])
+	default:
+		it = db.Scan(bounds[1], bounds[2])
+	}
+	defer it.Close()
+
+	for n := 0; it.Valid() && (*limit <= 0 || n < *limit); n++ {
+		k, v := it.Key(), it.Value()
+		text := o.keys.encode(k) + "\t" + o.values.encode(v)
+		if err := o.print(stdout, o.record(k, v, 0), text); err != nil {
+			return err
+		}
+		it.Next()
+	}
+	return it.Err()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+// runDump prints every pair as a line of JSON in key order, the format
+// load reads back. Keys and values default to base64 so any pair makes it
+// through.
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	var o output
+	o.keysFlag(fs, encBase64)
+	o.valuesFlag(fs, encBase64)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb dump [flags] <db>")
+	}
+
+	db, err := kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	it := db.Scan(nil, nil)
+	defer it.Close()
+	enc := json.NewEncoder(stdout)
+	for ; it.Valid(); it.Next() {
+		if err := enc.Encode(o.record(it.Key(), it.Value(), 0)); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// runLoad reads pairs written by dump from the file or stdin. An empty
+// database is bulk loaded in one commit, otherwise the pairs are written
+// in transactions of -batch pairs.
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	var o output
+	o.keysFlag(fs, encBase64)
+	o.valuesFlag(fs, encBase64)
+	sort := fs.Bool("sort", false, "sort the pairs first, when bulk loading input that isn't in key order")
+	batch := fs.Int("batch", 1000, "pairs per transaction when the database isn't empty")
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		return fmt.Errorf("usage: mydb load [flags] <db> [file]")
+	}
+	in := stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	src := &recordReader{dec: json.NewDecoder(bufio.NewReader(in)), o: &o}
+
+	db, err := kv.Open(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	n, err := load(db, src, *sort, max(*batch, 1))
+	if err := errors.Join(err, db.Close()); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d keys loaded\n", n)
+	return nil
+}
+
+func load(db *kv.KV, src *recordReader, sort bool, batch int) (int, error) {
+	it := db.Scan(nil, nil)
+	empty := !it.Valid()
+	err := it.Err()
+	it.Close()
+	if err != nil {
+		return 0, err
+	}
+	if empty {
+		n, err := db.BulkLoad(src, &kv.BulkOptions{Sort: sort})
+		if errors.Is(err, kv.ErrUnsorted) {
+			err = fmt.Errorf("%w, use -sort", err)
+		}
+		return n, err
+	}
+
+	n := 0
+	for done := false; !done; {
+		tx, err := db.Begin()
+		if err != nil {
+			return n, err
+		}
+		i := 0
+		for ; i < batch; i++ {
+			k, v, err := src.Next()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err == nil {
+				err = tx.Set(k, v)
+			}
+			if err != nil {
+				tx.Abort()
+				return n, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return n, err
+		}
+		n += i
+	}
+	return n, nil
+}
+
+// recordReader is a kv.PairSource over the lines written by dump.
+type recordReader struct {
+	dec  *json.Decoder
+	o    *output
+	line int
+}
+
+func (r *recordReader) Next() ([]byte, []byte, error) {
+	var rec record
+	if err := r.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("record %d: %w", r.line+1, err)
+	}
+	r.line++
+	k, err := r.o.keys.decode(rec.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("record %d: key: %w", r.line, err)
+	}
+	v, err := r.o.values.decode(rec.Value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("record %d: value: %w", r.line, err)
+	}
+	return k, v, nil
+}
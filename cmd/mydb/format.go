@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// encoding is how keys and values are written on the command line and in
+// the output. Binary data is best passed as hex or base64, raw bytes that
+// aren't valid UTF-8 don't survive JSON.
+type encoding string
+
+const (
+	encRaw    encoding = "raw"
+	encHex    encoding = "hex"
+	encBase64 encoding = "base64"
+)
+
+func (e *encoding) String() string {
+	return string(*e)
+}
+
+func (e *encoding) Set(s string) error {
+	switch encoding(s) {
+	case encRaw, encHex, encBase64:
+		*e = encoding(s)
+		return nil
+	}
+	return fmt.Errorf("unknown encoding %q, want raw, hex or base64", s)
+}
+
+func (e encoding) encode(b []byte) string {
+	switch e {
+	case encHex:
+		return hex.EncodeToString(b)
+	case encBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (e encoding) decode(s string) ([]byte, error) {
+	switch e {
+	case encHex:
+		return hex.DecodeString(s)
+	case encBase64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// output holds the flags that say how a command prints what it found.
+type output struct {
+	json   bool
+	keys   encoding
+	values encoding
+}
+
+func (o *output) jsonFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.json, "json", false, "print JSON instead of text")
+}
+
+func (o *output) keysFlag(fs *flag.FlagSet, enc encoding) {
+	o.keys = enc
+	fs.Var(&o.keys, "keys", "encoding of keys: raw, hex or base64")
+}
+
+func (o *output) valuesFlag(fs *flag.FlagSet, enc encoding) {
+	o.values = enc
+	fs.Var(&o.values, "values", "encoding of values: raw, hex or base64")
+}
+
+// record is a pair as printed with -json, and as read back by load.
+type record struct {
+	Key     string
+	Value   string
+	Version uint64 `json:",omitempty"`
+}
+
+func (o *output) record(k, v []byte, version uint64) record {
+	return record{Key: o.keys.encode(k), Value: o.values.encode(v), Version: version}
+}
+
+// print writes v as a line of JSON with -json, text otherwise.
+func (o *output) print(w io.Writer, v any, text string) error {
+	if o.json {
+		return json.NewEncoder(w).Encode(v)
+	}
+	_, err := fmt.Fprintln(w, text)
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/sys/unix"
+)
+
+// errInterrupt is returned by readLine when the line is dropped with ^C.
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads the lines typed in the shell.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+	addHistory(line string)
+}
+
+// plainReader reads lines from something that isn't a terminal, e.g. a
+// script piped in, without prompting.
+type plainReader struct {
+	sc *bufio.Scanner
+}
+
+func (r *plainReader) readLine(string) (string, error) {
+	if !r.sc.Scan() {
+		if r.sc.Err() != nil {
+			return "", r.sc.Err()
+		}
+		return "", io.EOF
+	}
+	return r.sc.Text(), nil
+}
+
+func (r *plainReader) addHistory(string) {}
+
+// lineEditor reads lines from a terminal it puts in raw mode while a line
+// is typed. It has the usual emacs keys: ^A ^E ^B ^F and the arrows move,
+// ^K ^U ^W cut, ^P ^N and up and down go through the history.
+type lineEditor struct {
+	fd      int // of the terminal, -1 to leave its mode alone
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+
+	prompt string
+	line   []rune
+	pos    int // of the cursor in line
+}
+
+const maxHistory = 1000
+
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+	e.prompt, e.line, e.pos = prompt, nil, 0
+	e.refresh()
+
+	// the history being browsed, the line typed so far goes at the end
+	browse := append(append([]string(nil), e.history...), "")
+	at := len(browse) - 1
+	recall := func(to int) {
+		if to < 0 || to >= len(browse) {
+			return
+		}
+		browse[at] = string(e.line)
+		at = to
+		e.line = []rune(browse[at])
+		e.pos = len(e.line)
+	}
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case 3: // ^C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // ^D
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case 127, 8: // backspace, ^H
+			e.delete(e.pos-1, e.pos)
+		case 1: // ^A
+			e.pos = 0
+		case 5: // ^E
+			e.pos = len(e.line)
+		case 2: // ^B
+			e.pos = max(e.pos-1, 0)
+		case 6: // ^F
+			e.pos = min(e.pos+1, len(e.line))
+		case 11: // ^K
+			e.delete(e.pos, len(e.line))
+		case 21: // ^U
+			e.delete(0, e.pos)
+		case 23: // ^W
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			e.delete(start, e.pos)
+		case 12: // ^L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // ^P
+			recall(at - 1)
+		case 14: // ^N
+			recall(at + 1)
+		case 27:
+			switch e.escape() {
+			case 'A':
+				recall(at - 1)
+			case 'B':
+				recall(at + 1)
+			case 'C':
+				e.pos = min(e.pos+1, len(e.line))
+			case 'D':
+				e.pos = max(e.pos-1, 0)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.line)
+			case '~': // delete
+				e.delete(e.pos, e.pos+1)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		e.refresh()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns the key it
+// stands for as the final letter of its arrow or home and end form, '~'
+// for delete and 0 for anything else.
+func (e *lineEditor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return 0
+	}
+	var param []rune
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r < '0' || r > '9' && r != ';' {
+			break
+		}
+		param = append(param, r)
+	}
+	if r != '~' {
+		return r
+	}
+	switch string(param) {
+	case "1", "7":
+		return 'H'
+	case "4", "8":
+		return 'F'
+	case "3":
+		return '~'
+	}
+	return 0
+}
+
+// delete removes the runes in [from, to), clamped to the line.
+func (e *lineEditor) delete(from, to int) {
+	from, to = max(from, 0), min(to, len(e.line))
+	if from >= to {
+		return
+	}
+	e.line = append(e.line[:from], e.line[to:]...)
+	if e.pos > to {
+		e.pos -= to - from
+	} else if e.pos > from {
+		e.pos = from
+	}
+}
+
+// refresh redraws the prompt and the line and puts the cursor back.
+func (e *lineEditor) refresh() {
+	var b strings.Builder
+	b.WriteString("\r" + e.prompt + string(e.line) + "\x1b[K")
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	io.WriteString(e.out, b.String())
+}
+
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+// makeRaw turns off the echo and the line handling of the terminal and
+// returns a func that puts them back.
+func makeRaw(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, old)
+	}, nil
+}
@@ -0,0 +1,84 @@
+// Command mydb works with database files of the kv package: it reads and
+// writes keys, dumps and loads whole databases and checks and repairs
+// files.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"get", "get [-json] [-keys enc] [-values enc] <db> <key>", runGet},
+	{"put", "put [-keys enc] [-values enc] <db> <key> [value]", runPut},
+	{"delete", "delete [-keys enc] <db> <key>", runDelete},
+	{"scan", "scan [-prefix p | -start k -end k] [-reverse] [-limit n] [-json] [-keys enc] [-values enc] <db>", runScan},
+	{"stats", "stats [-json] <db>", runStats},
+	{"shell", "shell [-readonly] [-timing=false] [-history file] [-keys enc] [-values enc] <db>", runShell},
+	{"resp", "resp [-addr host:port] [-nosync] <db>", runResp},
+	{"http", "http [-addr host:port] [-nosync] <db>", runHTTP},
+	{"dump", "dump [-keys enc] [-values enc] <db>", runDump},
+	{"load", "load [-sort] [-batch n] [-keys enc] [-values enc] <db> [file]", runLoad},
+	{"check", "check [-json] <db>", runCheck},
+	{"compact", "compact [-json] <db>", runCompact},
+	{"salvage", "salvage [-json] <damaged db> <new db>", runSalvage},
+	{"vacuum", "vacuum [-json] <db>", runVacuum},
+}
+
+// where the commands read and print, replaced by the tests
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			w := bufio.NewWriter(os.Stdout)
+			stdout = w
+			err := c.run(flag.Args()[1:])
+			w.Flush()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "mydb %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "mydb: unknown command %q\n", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mydb <command> [arguments]")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  mydb %s\n", c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "keys and values are encoded as raw, hex or base64, see mydb <command> -h")
+}
+
+// openExisting opens a read-write store without creating the file.
+func openExisting(filename string, opts *kv.Options) (*kv.KV, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return kv.Open(filename, opts)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+// run runs the command with args and returns what it printed.
+func run(t *testing.T, in string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	stdin, stdout = strings.NewReader(in), &out
+	for _, c := range commands {
+		if c.name == args[0] {
+			err := c.run(args[1:])
+			return out.String(), err
+		}
+	}
+	t.Fatalf("no command %s", args[0])
+	return "", nil
+}
+
+func mustRun(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := run(t, "", args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+func TestCommands(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "cli.db")
+	for i := range 5 {
+		mustRun(t, "put", db, fmt.Sprintf("k_%d", i), fmt.Sprintf("v_%d", i))
+	}
+	mustRun(t, "put", "-keys", "hex", "-values", "base64", db, "00ff", "AAEC")
+	if _, err := run(t, "from stdin", "put", db, "stdin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := mustRun(t, "get", db, "k_3"); out != "v_3\n" {
+		t.Fatalf("get: %q", out)
+	}
+	if out := mustRun(t, "get", db, "stdin"); out != "from stdin\n" {
+		t.Fatalf("get of a value read from stdin: %q", out)
+	}
+	if out := mustRun(t, "get", "-keys", "hex", "-values", "hex", db, "00ff"); out != "000102\n" {
+		t.Fatalf("get of a binary pair: %q", out)
+	}
+	var rec record
+	if err := json.Unmarshal([]byte(mustRun(t, "get", "-json", db, "k_0")), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Key != "k_0" || rec.Value != "v_0" || rec.Version == 0 {
+		t.Fatalf("get -json: %+v", rec)
+	}
+	if _, err := run(t, "", "get", db, "missing"); !errors.Is(err, kv.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	if _, err := run(t, "", "delete", db, "k_4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "", "delete", db, "k_4"); !errors.Is(err, kv.ErrNotFound) {
+		t.Fatalf("delete of a missing key: %v", err)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-prefix", "k_"}, "k_0\tv_0\nk_1\tv_1\nk_2\tv_2\nk_3\tv_3\n"},
+		{[]string{"-prefix", "k_", "-reverse", "-limit", "2"}, "k_3\tv_3\nk_2\tv_2\n"},
+		{[]string{"-start", "k_1", "-end", "k_3"}, "k_1\tv_1\nk_2\tv_2\n"},
+		{[]string{"-keys", "hex", "-values", "hex", "-end", "01"}, "00ff\t000102\n"},
+		{[]string{"-json", "-start", "stdin"}, `{"Key":"stdin","Value":"from stdin"}` + "\n"},
+	}
+	for _, tt := range tests {
+		if out := mustRun(t, append(append([]string{"scan"}, tt.args...), db)...); out != tt.expected {
+			t.Fatalf("scan %v: expected %q got %q", tt.args, tt.expected, out)
+		}
+	}
+	if _, err := run(t, "", "scan", "-prefix", "k", "-start", "a", db); err == nil {
+		t.Fatal("-prefix and -start together should fail")
+	}
+
+	var s kv.Stats
+	if err := json.Unmarshal([]byte(mustRun(t, "stats", "-json", db)), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Version == 0 || s.Pages == 0 {
+		t.Fatalf("stats: %+v", s)
+	}
+	if out := mustRun(t, "check", db); !strings.Contains(out, "holding 6 keys") {
+		t.Fatalf("check: %q", out)
+	}
+}
+
+func TestDumpLoad(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.db"), filepath.Join(dir, "dst.db")
+	db, err := kv.Open(src, &kv.Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 300 {
+		if err := db.Insert([]byte{byte(i >> 8), byte(i), 0xff}, bytes.Repeat([]byte{byte(i)}, i*7)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := mustRun(t, "dump", src)
+	if out, err := run(t, dump, "load", dst); err != nil || out != "300 keys loaded\n" {
+		t.Fatalf("bulk load: %q %v", out, err)
+	}
+	if again := mustRun(t, "dump", dst); again != dump {
+		t.Fatal("the loaded database dumps differently")
+	}
+
+	// into a database that isn't empty, in transactions
+	lines := strings.SplitAfter(dump, "\n")
+	extra := `{"Key":"enRv","Value":"YQ=="}` + "\n" // zto: a
+	if out, err := run(t, extra+strings.Join(lines[:10], ""), "load", "-batch", "3", dst); err != nil || out != "11 keys loaded\n" {
+		t.Fatalf("load: %q %v", out, err)
+	}
+	if out := mustRun(t, "get", dst, "zto"); out != "a\n" {
+		t.Fatalf("get of a loaded key: %q", out)
+	}
+
+	// unsorted input needs -sort to be bulk loaded
+	unsorted := extra + dump
+	if _, err := run(t, unsorted, "load", filepath.Join(dir, "unsorted.db")); !errors.Is(err, kv.ErrUnsorted) {
+		t.Fatalf("expected ErrUnsorted got %v", err)
+	}
+	if out, err := run(t, unsorted, "load", "-sort", filepath.Join(dir, "sorted.db")); err != nil || out != "301 keys loaded\n" {
+		t.Fatalf("load -sort: %q %v", out, err)
+	}
+	if _, err := run(t, "{bad", "load", filepath.Join(dir, "bad.db")); err == nil {
+		t.Fatal("expected an error for a bad record")
+	}
+}
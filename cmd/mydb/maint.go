@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb stats [flags] <db>")
+	}
+	db, err := kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	s, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	return o.print(stdout, s, fmt.Sprintf("version %d: %d pages, %d bytes, %d free pages, tree height %d",
+		s.Version, s.Pages, s.Size, s.FreePages, s.Height))
+}
+
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb check [flags] <db>")
+	}
+	db, err := kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r, err := db.Check()
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("version %d: %d pages, %d in the tree holding %d keys, %d free, %d in the freelist",
+		r.Version, r.Pages, r.Tree, r.Keys, r.Free, r.FreeList)
+	for _, p := range r.Problems {
+		text += "\n" + p.String()
+	}
+	if err := o.print(stdout, r, text); err != nil {
+		return err
+	}
+	if !r.OK() {
+		return fmt.Errorf("%d problems", len(r.Problems))
+	}
+	return nil
+}
+
+func runCompact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb compact [flags] <db>")
+	}
+	stats, err := kv.Compact(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	return o.print(stdout, stats, fmt.Sprintf("%d keys, %d -> %d bytes", stats.Keys, stats.SizeBefore, stats.SizeAfter))
+}
+
+func runVacuum(args []string) error {
+	fs := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb vacuum [flags] <db>")
+	}
+	db, err := openExisting(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	stats, err := db.Vacuum()
+	if err := errors.Join(err, db.Close()); err != nil {
+		return err
+	}
+	return o.print(stdout, stats, fmt.Sprintf("%d pages moved, %d -> %d bytes", stats.Moved, stats.SizeBefore, stats.SizeAfter))
+}
+
+func runSalvage(args []string) error {
+	fs := flag.NewFlagSet("salvage", flag.ExitOnError)
+	var o output
+	o.jsonFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: mydb salvage [flags] <damaged db> <new db>")
+	}
+	stats, err := kv.Salvage(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return o.print(stdout, stats, fmt.Sprintf("%d pages, %d corrupt, %d free, %d leaves: %d keys recovered, %d lost",
+		stats.Pages, stats.Corrupt, stats.Free, stats.Leaves, stats.Keys, stats.Lost))
+}
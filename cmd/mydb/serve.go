@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/httpapi"
+	"github.com/GiorgosMarga/my_db/kv"
+	"github.com/GiorgosMarga/my_db/resp"
+)
+
+// runResp serves the database to redis clients until interrupted.
+func runResp(args []string) error {
+	fs := flag.NewFlagSet("resp", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:6379", "`address` to listen on")
+	noSync := fs.Bool("nosync", false, "don't fsync each commit, see kv.Options")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb resp [flags] <db>")
+	}
+	db, err := kv.Open(fs.Arg(0), &kv.Options{NoSync: *noSync})
+	if err != nil {
+		return err
+	}
+	s := resp.NewServer(db)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(*addr)
+	}()
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", fs.Arg(0), *addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-done: // couldn't listen
+	case <-stop:
+		err = s.Close()
+		if serveErr := <-done; !errors.Is(serveErr, resp.ErrServerClosed) {
+			err = errors.Join(err, serveErr)
+		}
+	}
+	if err != nil {
+		s.Close()
+	}
+	return errors.Join(err, db.Close())
+}
+
+// runHTTP serves the database over HTTP until interrupted, see httpapi.
+func runHTTP(args []string) error {
+	fs := flag.NewFlagSet("http", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "`address` to listen on")
+	noSync := fs.Bool("nosync", false, "don't fsync each commit, see kv.Options")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb http [flags] <db>")
+	}
+	db, err := kv.Open(fs.Arg(0), &kv.Options{NoSync: *noSync})
+	if err != nil {
+		return err
+	}
+	s := &http.Server{
+		Addr:    *addr,
+		Handler: httpapi.NewServer(db),
+		// a client can't keep a connection, or a value it sends, hanging
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "serving %s on http://%s\n", fs.Arg(0), *addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-done: // couldn't listen
+	case <-stop:
+		// requests in flight finish, streams included
+		err = s.Shutdown(context.Background())
+		if serveErr := <-done; !errors.Is(serveErr, http.ErrServerClosed) {
+			err = errors.Join(err, serveErr)
+		}
+	}
+	return errors.Join(err, db.Close())
+}
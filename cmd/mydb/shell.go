@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+const shellHelp = `GET key                  print the value of key
+SET key value            set key to value
+DEL key [key...]         delete keys
+SCAN [PREFIX p] [FROM k] [TO k] [REVERSE] [LIMIT n]
+                         print the pairs in [FROM, TO), 100 at most by default
+BEGIN                    start a transaction, the commands above go through it
+COMMIT                   commit the transaction
+ABORT                    drop the transaction
+STATS                    print the stats of the database
+HELP                     print this
+EXIT                     leave, aborting an open transaction
+Arguments may be double quoted with Go escapes, e.g. "a key\x00".`
+
+const defaultScanLimit = 100
+
+var errExit = errors.New("exit")
+
+// shell runs the commands typed in runShell against one open store.
+type shell struct {
+	db  *kv.KV
+	tx  *kv.Tx // open transaction, nil outside of BEGIN and COMMIT or ABORT
+	o   output
+	out io.Writer
+}
+
+// runShell opens the database once and runs commands read from stdin, with
+// line editing and history when stdin is a terminal.
+func runShell(args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	s := &shell{out: stdout}
+	s.o.keysFlag(fs, encRaw)
+	s.o.valuesFlag(fs, encRaw)
+	readOnly := fs.Bool("readonly", false, "open the database read-only")
+	timing := fs.Bool("timing", true, "print how long each command took")
+	history := fs.String("history", defaultHistory(), "`file` keeping the history of commands, empty for none")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: mydb shell [flags] <db>")
+	}
+
+	var err error
+	if *readOnly {
+		s.db, err = kv.Open(fs.Arg(0), &kv.Options{ReadOnly: true})
+	} else {
+		s.db, err = kv.Open(fs.Arg(0), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	var lines lineReader = &plainReader{sc: bufio.NewScanner(stdin)}
+	var save *os.File
+	if f, ok := stdin.(*os.File); ok && isTerminal(int(f.Fd())) {
+		e := &lineEditor{fd: int(f.Fd()), in: bufio.NewReader(f), out: os.Stdout}
+		if *history != "" {
+			loadHistory(e, *history)
+			// history that can't be kept isn't worth failing for
+			save, _ = os.OpenFile(*history, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		}
+		lines = e
+	}
+	if save != nil {
+		defer func() {
+			save.Close()
+			trimHistory(*history, maxHistory)
+		}()
+	}
+
+	for {
+		prompt := "mydb> "
+		if s.tx != nil {
+			prompt = "mydb(tx)> "
+		}
+		line, err := lines.readLine(prompt)
+		if err == errInterrupt {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.close()
+				return err
+			}
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines.addHistory(line)
+		if save != nil {
+			fmt.Fprintln(save, line)
+		}
+
+		start := time.Now()
+		err = s.exec(line)
+		if err == errExit {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+		if *timing {
+			fmt.Fprintf(s.out, "(%v)\n", time.Since(start).Round(time.Microsecond))
+		}
+		if f, ok := s.out.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	return s.close()
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mydb_history")
+}
+
+func loadHistory(e *lineEditor, filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		e.addHistory(sc.Text())
+	}
+}
+
+// trimHistory keeps the newest n lines of the history file, which every
+// shell appends to as it goes, when the shell exits. The file is replaced
+// by a rename so it's never seen half written, a line another shell
+// appends meanwhile may be lost.
+func trimHistory(filename string, n int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	// the newest n lines are in a ring, from next
+	ring := make([]string, 0, n)
+	next, total := 0, 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		total++
+		if len(ring) < n {
+			ring = append(ring, sc.Text())
+			continue
+		}
+		ring[next] = sc.Text()
+		next = (next + 1) % n
+	}
+	f.Close()
+	if err := sc.Err(); err != nil || total <= n {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for i := range ring {
+		fmt.Fprintln(w, ring[(next+i)%len(ring)])
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// close aborts the open transaction, if any, and closes the store.
+func (s *shell) close() error {
+	if s.tx != nil {
+		s.tx.Abort()
+		s.tx = nil
+		fmt.Fprintln(s.out, "open transaction aborted")
+	}
+	return s.db.Close()
+}
+
+// exec runs one command line. Errors are for the user, except errExit.
+func (s *shell) exec(line string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	name, args := args[0], args[1:]
+	switch strings.ToUpper(name) {
+	case "GET":
+		return s.get(args)
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		return s.del(args)
+	case "SCAN":
+		return s.scan(args)
+	case "BEGIN":
+		if s.tx != nil {
+			return errors.New("a transaction is already open")
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	case "COMMIT":
+		if s.tx == nil {
+			return errors.New("no open transaction")
+		}
+		tx := s.tx
+		s.tx = nil
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	case "ABORT":
+		if s.tx == nil {
+			return errors.New("no open transaction")
+		}
+		s.tx.Abort()
+		s.tx = nil
+	case "STATS":
+		st, err := s.db.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "version %d: %d pages, %d bytes, %d free pages, tree height %d, %d snapshots open\n",
+			st.Version, st.Pages, st.Size, st.FreePages, st.Height, st.Snapshots)
+		return nil
+	case "HELP":
+		fmt.Fprintln(s.out, shellHelp)
+		return nil
+	case "EXIT", "QUIT":
+		return errExit
+	default:
+		return fmt.Errorf("unknown command %s, try HELP", name)
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+func (s *shell) get(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: GET key")
+	}
+	k, err := s.o.keys.decode(args[0])
+	if err != nil {
+		return err
+	}
+	var v []byte
+	if s.tx != nil {
+		v, err = s.tx.Get(k)
+	} else {
+		v, err = s.db.Get(k)
+	}
+	if errors.Is(err, kv.ErrNotFound) {
+		fmt.Fprintln(s.out, "(nil)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, s.o.values.encode(v))
+	return nil
+}
+
+func (s *shell) set(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: SET key value")
+	}
+	k, err := s.o.keys.decode(args[0])
+	if err != nil {
+		return err
+	}
+	v, err := s.o.values.decode(args[1])
+	if err != nil {
+		return err
+	}
+	if s.tx != nil {
+		err = s.tx.Set(k, v)
+	} else {
+		err = s.db.Insert(k, v)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+// del prints how many keys were deleted, inside a transaction it can't tell
+// without reading them so it only says OK.
+func (s *shell) del(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: DEL key [key...]")
+	}
+	keys := make([][]byte, len(args))
+	for i, arg := range args {
+		k, err := s.o.keys.decode(arg)
+		if err != nil {
+			return err
+		}
+		keys[i] = k
+	}
+	n := 0
+	for _, k := range keys {
+		var err error
+		if s.tx != nil {
+			err = s.tx.Delete(k)
+		} else {
+			err = s.db.Delete(k)
+		}
+		if errors.Is(err, kv.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if s.tx != nil {
+		fmt.Fprintln(s.out, "OK")
+	} else {
+		fmt.Fprintf(s.out, "%d deleted\n", n)
+	}
+	return nil
+}
+
+func (s *shell) scan(args []string) error {
+	var prefix, from, to []byte
+	reverse, limit := false, defaultScanLimit
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "REVERSE" {
+			reverse = true
+			continue
+		}
+		if i+1 == len(args) {
+			return fmt.Errorf("%s needs an argument", args[i])
+		}
+		i++
+		var err error
+		switch opt {
+		case "PREFIX":
+			prefix, err = s.o.keys.decode(args[i])
+		case "FROM":
+			from, err = s.o.keys.decode(args[i])
+		case "TO":
+			to, err = s.o.keys.decode(args[i])
+		case "LIMIT":
+			limit, err = strconv.Atoi(args[i])
+		default:
+			return fmt.Errorf("unknown SCAN option %s", args[i-1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if prefix != nil && (from != nil || to != nil) {
+		return errors.New("PREFIX can't be used with FROM or TO")
+	}
+
+	var it *kv.Iterator
+	switch {
+	case s.tx != nil && prefix != nil && reverse:
+		it = s.tx.ScanPrefixReverse(prefix)
+	case s.tx != nil && prefix != nil:
+		it = s.tx.ScanPrefix(prefix)
+	case s.tx != nil && reverse:
+		it = s.tx.ScanReverse(from, to)
+	case s.tx != nil:
+		it = s.tx.Scan(from, to)
+	case prefix != nil && reverse:
+		it = s.db.ScanPrefixReverse(prefix)
+	case prefix != nil:
+		it = s.db.ScanPrefix(prefix)
+	case reverse:
+		it = s.db.ScanReverse(from, to)
+	default:
+		it = s.db.Scan(from, to)
+	}
+	defer it.Close()
+
+	n := 0
+	for ; it.Valid() && (limit <= 0 || n < limit); it.Next() {
+		fmt.Fprintf(s.out, "%s\t%s\n", s.o.keys.encode(it.Key()), s.o.values.encode(it.Value()))
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	more := ""
+	if it.Valid() {
+		more = ", more left"
+	}
+	fmt.Fprintf(s.out, "(%d pairs%s)\n", n, more)
+	return nil
+}
+
+// splitArgs splits line on spaces. An argument may be double quoted, with
+// the escapes of a Go string.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for line = strings.TrimLeft(line, " \t"); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+		end := 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, errors.New("missing closing quote")
+		}
+		arg, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted argument %s: %w", line[:end+1], err)
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+	}
+	return args, nil
+}
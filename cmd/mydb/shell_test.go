@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "shell.db")
+	script := `
+SET a 1
+set b 2
+SET "c d" "\x00\xff"
+GET a
+GET "c d"
+GET missing
+DEL a missing
+BEGIN
+SET e 5
+GET e
+DEL b
+SCAN
+ABORT
+GET e
+BEGIN
+SET f 6
+COMMIT
+SCAN REVERSE LIMIT 2
+SCAN PREFIX c
+SCAN FROM b TO f
+COMMIT
+NOPE x
+SET a
+BEGIN
+SET g 7
+`
+	out, err := run(t, script, "shell", "-timing=false", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `OK
+OK
+OK
+1
+"\x00\xff"
+(nil)
+1 deleted
+OK
+OK
+5
+OK
+c d	` + "\x00\xff" + `
+e	5
+(2 pairs)
+OK
+(nil)
+OK
+OK
+OK
+f	6
+c d	` + "\x00\xff" + `
+(2 pairs, more left)
+c d	` + "\x00\xff" + `
+(1 pairs)
+b	2
+c d	` + "\x00\xff" + `
+(2 pairs)
+error: no open transaction
+error: unknown command NOPE, try HELP
+error: usage: SET key value
+OK
+OK
+open transaction aborted
+`
+	expected = strings.Replace(expected, `"\x00\xff"`, "\x00\xff", 1)
+	if out != expected {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, out)
+	}
+
+	out, err = run(t, "GET g\nSTATS\nEXIT\nGET a\n", "shell", "-readonly", "-values", "hex", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) != 5 || lines[0] != "(nil)" || !strings.HasPrefix(lines[2], "version ") || !strings.HasSuffix(lines[1], "s)") {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"GET a", []string{"GET", "a"}},
+		{"  SET\ta   b ", []string{"SET", "a", "b"}},
+		{`SET "a b" "c\"d\x00"`, []string{"SET", "a b", "c\"d\x00"}},
+		{`SET "" x`, []string{"SET", "", "x"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+			t.Fatalf("%q: expected %q got %q %v", tt.line, tt.expected, got, err)
+		}
+	}
+	for _, line := range []string{`GET "a`, `GET "a\"`, `GET "\q"`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Fatalf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	e := &lineEditor{fd: -1, out: io.Discard}
+	read := func(keys string) (string, error) {
+		e.in = bufio.NewReader(strings.NewReader(keys))
+		return e.readLine("> ")
+	}
+
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"GET a\r", "GET a"},
+		{"GT a\x1b[D\x1b[D\x1b[DE\r", "GET a"},   // left arrow, insert
+		{"ET a\x01G\x05b\r", "GET ab"},           // ^A, ^E
+		{"GET abc\x7f\x7f\r", "GET a"},           // backspace
+		{"SET a b\x17\x17c d\r", "SET c d"},      // ^W
+		{"SET a b\x01\x06\x06\x06\x0b\r", "SET"}, // ^F, ^K
+		{"xx GET\x02\x02\x02\x15\r", "GET"},      // ^B, ^U
+		{"GET a\x1b[H\x1b[3~S\r", "SET a"},       // home, delete
+		{"\x1b[A\r", "SET a"},                    // up goes back to the last line
+		{"\x1b[A\x1b[A\x1b[B\r", "SET a"},
+		{"\x10\x10\x10\r", "SET"}, // ^P three times: SET a, GET, SET
+	}
+	for _, tt := range tests {
+		line, err := read(tt.keys)
+		if err != nil || line != tt.expected {
+			t.Fatalf("%q: expected %q got %q %v", tt.keys, tt.expected, line, err)
+		}
+		e.addHistory(line)
+	}
+
+	if _, err := read("abc\x03"); err != errInterrupt {
+		t.Fatalf("^C: expected errInterrupt got %v", err)
+	}
+	if _, err := read("\x04"); err != io.EOF {
+		t.Fatalf("^D: expected io.EOF got %v", err)
+	}
+	if line, _ := read("ab\x02\x04\r"); line != "a" {
+		t.Fatalf("^D inside a line deletes: %q", line)
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "history")
+	var b strings.Builder
+	for i := range 25 {
+		fmt.Fprintf(&b, "GET %d\n", i)
+	}
+	if err := os.WriteFile(name, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trimHistory(name, 30); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(name); string(got) != b.String() {
+		t.Fatalf("a short history was changed: %q", got)
+	}
+	if err := trimHistory(name, 10); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	if len(lines) != 10 || lines[0] != "GET 15" || lines[9] != "GET 24" {
+		t.Fatalf("trimmed to %q", lines)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Fatalf("%d files left behind", len(entries))
+	}
+}
@@ -2,14 +2,15 @@ package freelist
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/GiorgosMarga/my_db/btree"
 )
 
 type FreeList struct {
-	Get    func(uint64) []byte
-	Update func(uint64) []byte
-	New    func([]byte) uint64
+	Get    func(uint64) ([]byte, error)
+	Update func(uint64) ([]byte, error)
+	New    func([]byte) (uint64, error)
 
 	HeadPage uint64
 	HeadIdx  uint64
@@ -18,67 +19,173 @@ type FreeList struct {
 	TailIdx  uint64
 
 	MaxIdx uint64
+
+	// open readers, keyed by the TailIdx they started at. Pages pushed at or
+	// after that index may still be reachable from the reader's root.
+	mu      sync.Mutex
+	readers map[uint64]int
 }
 
 func (fl *FreeList) getIdx(idx uint64) uint64 {
 	return idx % MAX_PTRS
 }
-func (fl *FreeList) PopHead() uint64 {
-	ptr, head := fl.pop()
+
+// PopHead returns a free page or 0 if there is none available.
+func (fl *FreeList) PopHead() (uint64, error) {
+	ptr, head, err := fl.pop()
+	if err != nil {
+		return 0, err
+	}
 	if head != 0 {
 		// recycle node
-		fl.PushTail(head)
+		if err := fl.PushTail(head); err != nil {
+			return 0, err
+		}
 	}
-	return ptr
+	return ptr, nil
 }
-func (fl *FreeList) pop() (uint64, uint64) {
+func (fl *FreeList) pop() (uint64, uint64, error) {
 	if fl.HeadIdx == fl.MaxIdx {
-		return 0, 0
+		return 0, 0, nil
+	}
+	data, err := fl.Get(fl.HeadPage)
+	if err != nil {
+		return 0, 0, err
 	}
-	next := LNode(fl.Get(fl.HeadPage)).getNext()
-	ptr := LNode(fl.Get(fl.HeadPage)).getPtr(fl.getIdx(fl.HeadIdx))
+	next := LNode(data).getNext()
+	ptr := LNode(data).getPtr(fl.getIdx(fl.HeadIdx))
 
 	fl.HeadIdx++
 
 	if fl.getIdx(fl.HeadIdx) == 0 {
 		if next == 0 {
-			panic("asda")
+			return 0, 0, fmt.Errorf("%w: freelist page %d has no next page", btree.ErrCorrupt, fl.HeadPage)
 		}
 		// this head page has no more available ptrs
 		head := fl.HeadPage
 		fl.HeadPage = next
-		return ptr, head
+		return ptr, head, nil
 	}
-	return ptr, 0
+	return ptr, 0, nil
 }
 
-func (fl *FreeList) PushTail(ptr uint64) {
-	LNode(fl.Update(fl.TailPage)).setPtr(fl.getIdx(fl.TailIdx), ptr)
+func (fl *FreeList) PushTail(ptr uint64) error {
+	tail, err := fl.Update(fl.TailPage)
+	if err != nil {
+		return err
+	}
+	LNode(tail).setPtr(fl.getIdx(fl.TailIdx), ptr)
 
 	fl.TailIdx++
 	if fl.getIdx(fl.TailIdx) != 0 {
 		// tail node has available space for more ptrs
-		return
+		return nil
 	}
 	// recycle head page if exists or create a new page
 
-	next, head := fl.pop()
+	next, head, err := fl.pop()
+	if err != nil {
+		return err
+	}
 	if next == 0 {
 		// allocate new page
-		next = fl.New(make([]byte, btree.BNODE_PAGE_SIZE))
+		next, err = fl.New(make([]byte, btree.BNODE_PAGE_SIZE))
+		if err != nil {
+			return err
+		}
 	}
 
-	LNode(fl.Update(fl.TailPage)).setNext(next)
+	LNode(tail).setNext(next)
 	fl.TailPage = next
 	// also add the head node if it's removed
 	if head != 0 {
-		fmt.Println("head was removed")
-		LNode(fl.Update(fl.TailPage)).setPtr(0, head)
+		newTail, err := fl.Update(fl.TailPage)
+		if err != nil {
+			return err
+		}
+		LNode(newTail).setPtr(0, head)
 		fl.TailIdx++
 	}
-
+	return nil
 }
 
+// SetMaxIdx makes every pushed page available to pop, except the ones
+// still visible to an open reader.
 func (fl *FreeList) SetMaxIdx() {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
 	fl.MaxIdx = fl.TailIdx
+	for idx := range fl.readers {
+		fl.MaxIdx = min(fl.MaxIdx, idx)
+	}
+}
+
+// AddReader holds back reuse of pages pushed at or after idx until the
+// matching RemoveReader.
+func (fl *FreeList) AddReader(idx uint64) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.readers == nil {
+		fl.readers = make(map[uint64]int)
+	}
+	fl.readers[idx]++
+}
+
+func (fl *FreeList) RemoveReader(idx uint64) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	fl.readers[idx]--
+	if fl.readers[idx] <= 0 {
+		delete(fl.readers, idx)
+	}
+}
+
+// Walk calls fn with the index and the page of every entry from the head
+// to the tail and returns the pages the list is made of, in order.
+func (fl *FreeList) Walk(fn func(idx, ptr uint64) error) ([]uint64, error) {
+	nodes := []uint64{fl.HeadPage}
+	data, err := fl.Get(fl.HeadPage)
+	if err != nil {
+		return nil, err
+	}
+	next := func() error {
+		ptr := LNode(data).getNext()
+		if ptr == 0 {
+			return fmt.Errorf("%w: freelist page %d has no next page", btree.ErrCorrupt, nodes[len(nodes)-1])
+		}
+		nodes = append(nodes, ptr)
+		data, err = fl.Get(ptr)
+		return err
+	}
+	for idx := fl.HeadIdx; idx != fl.TailIdx; idx++ {
+		if idx != fl.HeadIdx && fl.getIdx(idx) == 0 {
+			if err := next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := fn(idx, LNode(data).getPtr(fl.getIdx(idx))); err != nil {
+			return nil, err
+		}
+	}
+	// a full last page links to an empty tail page
+	if fl.TailIdx != fl.HeadIdx && fl.getIdx(fl.TailIdx) == 0 {
+		if err := next(); err != nil {
+			return nil, err
+		}
+	}
+	if last := nodes[len(nodes)-1]; last != fl.TailPage {
+		return nil, fmt.Errorf("%w: freelist ends at page %d, expected %d", btree.ErrCorrupt, last, fl.TailPage)
+	}
+	return nodes, nil
+}
+
+// Reset empties the list, it starts over on page, which must be zeroed,
+// at idx. Nothing pushed is available to pop until the next SetMaxIdx.
+func (fl *FreeList) Reset(page, idx uint64) {
+	fl.HeadPage, fl.TailPage = page, page
+	fl.HeadIdx, fl.TailIdx = idx, idx
+	fl.MaxIdx = idx
 }
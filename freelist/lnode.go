@@ -8,33 +8,36 @@ import (
 
 type LNode []byte
 
-// NEXT PTR | PAGE PTRS
-// 8B          max_ptrs * 8b
+// HEADER | NEXT PTR | PAGE PTRS
+// 8B       8B         max_ptrs * 8b
+//
+// the header has the same size as btree.BNode's so the page checksum sits
+// at btree.CHECKSUM_OFFSET for every page
 
 const (
+	HEADER_SIZE   = btree.HEADER_SIZE
 	PTR_SIZE      = 8
 	NEXT_PTR_SIZE = 8
-	MAX_PTRS      = (btree.BNODE_PAGE_SIZE - NEXT_PTR_SIZE) / PTR_SIZE
+	MAX_PTRS      = (btree.BNODE_PAGE_SIZE - HEADER_SIZE - NEXT_PTR_SIZE) / PTR_SIZE
 )
 
 func (n LNode) setNext(ptr uint64) {
-	binary.LittleEndian.PutUint64(n[0:], ptr)
+	binary.LittleEndian.PutUint64(n[HEADER_SIZE:], ptr)
 }
 
 func (n LNode) getNext() uint64 {
-	return binary.LittleEndian.Uint64(n[0:])
+	return binary.LittleEndian.Uint64(n[HEADER_SIZE:])
 }
 
 func (n LNode) setPtr(idx, ptr uint64) {
-	pos := NEXT_PTR_SIZE + idx*PTR_SIZE
+	pos := HEADER_SIZE + NEXT_PTR_SIZE + idx*PTR_SIZE
 	binary.LittleEndian.PutUint64(n[pos:], ptr)
 }
 
 func (n LNode) getPtr(idx uint64) uint64 {
-	pos := NEXT_PTR_SIZE + idx*PTR_SIZE
+	pos := HEADER_SIZE + NEXT_PTR_SIZE + idx*PTR_SIZE
 	return binary.LittleEndian.Uint64(n[pos:])
 }
 func (n LNode) SetNext(ptr uint64) {
-	binary.LittleEndian.PutUint64(n[0:], ptr)
-
+	n.setNext(ptr)
 }
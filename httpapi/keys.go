@@ -0,0 +1,238 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+// pathKey returns the key of /keys/{key}, decoded with the encoding
+// parameter.
+func pathKey(r *http.Request) ([]byte, error) {
+	enc, err := parseEncoding(r.URL.Query().Get("encoding"))
+	if err != nil {
+		return nil, err
+	}
+	k, err := enc.decode(r.PathValue("key"))
+	if err != nil {
+		return nil, err
+	}
+	if len(k) == 0 {
+		return nil, badRequest("empty key")
+	}
+	return k, nil
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// ifMatch returns the version of an If-Match header holding one ETag, it
+// reports false without the header and "*" is any version.
+func ifMatch(r *http.Request) (version uint64, anyVersion, found bool, err error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return 0, false, false, nil
+	}
+	if h == "*" {
+		return 0, true, true, nil
+	}
+	version, err = strconv.ParseUint(strings.Trim(strings.TrimPrefix(h, "W/"), `"`), 10, 64)
+	if err != nil || version == 0 {
+		return 0, false, false, badRequest("If-Match must be a single version, as sent in the ETag")
+	}
+	return version, false, true, nil
+}
+
+// getKey sends the value as is, streamed from its pages. Ranges and
+// If-None-Match are handled by http.ServeContent.
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	vr, err := s.db.OpenReader(k)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer vr.Close()
+	w.Header().Set("ETag", etag(vr.Version()))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, vr)
+}
+
+// putKey sets the key to the body. The body is read whole before the write
+// starts: streaming it with kv.PutReader would hold the writer lock for as
+// long as the client takes to send it.
+func (s *Server) putKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err == nil {
+		err = s.put(w, r, k)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, k []byte) error {
+	if r.ContentLength > s.MaxValueSize {
+		return &http.MaxBytesError{Limit: s.MaxValueSize}
+	}
+	body := http.MaxBytesReader(w, r.Body, s.MaxValueSize)
+	version, anyVersion, match, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+	create := strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+	if match && create {
+		return badRequest("If-Match and If-None-Match can't be used together")
+	}
+
+	v, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	var done bool
+	switch {
+	case create:
+		done, err = s.db.SetIfVersion(k, v, 0)
+	case anyVersion:
+		req := &kv.UpdateReq{Key: k, Val: v, Mode: kv.MODE_UPDATE_ONLY, NoOld: true}
+		err = s.db.Update(req)
+		done = req.Updated
+	case match:
+		done, err = s.db.SetIfVersion(k, v, version)
+	default:
+		done, err = true, s.db.Insert(k, v)
+	}
+	if err == nil && !done {
+		err = errStale
+	}
+	return err
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, anyVersion, match, err := ifMatch(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if match && !anyVersion {
+		var done bool
+		done, err = s.db.DeleteIfVersion(k, version)
+		if err == nil && !done {
+			err = errStale
+		}
+	} else {
+		err = s.db.Delete(k)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type batchRequest struct {
+	Encoding string    `json:"encoding"` // of the keys and values
+	Ops      []batchOp `json:"ops"`
+}
+
+type batchOp struct {
+	Op    string `json:"op"` // put or delete
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type batchResponse struct {
+	Applied int `json:"applied"`
+}
+
+// batch applies every op of the request in one transaction, all of them
+// or none.
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.MaxBatchSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooBig *http.MaxBytesError
+		if !errors.As(err, &tooBig) {
+			err = badRequest("%v", err)
+		}
+		writeError(w, err)
+		return
+	}
+	enc, err := parseEncoding(req.Encoding)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for i, op := range req.Ops {
+		if err := apply(tx, enc, op); err != nil {
+			tx.Abort()
+			writeError(w, opError{i: i, err: err})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, batchResponse{Applied: len(req.Ops)})
+}
+
+func apply(tx *kv.Tx, enc encoding, op batchOp) error {
+	k, err := enc.decode(op.Key)
+	if err != nil {
+		return err
+	}
+	if len(k) == 0 {
+		return badRequest("empty key")
+	}
+	switch op.Op {
+	case "put":
+		v, err := enc.decode(op.Value)
+		if err != nil {
+			return err
+		}
+		return tx.Set(k, v)
+	case "delete":
+		return tx.Delete(k)
+	}
+	return badRequest("unknown op %q, want put or delete", op.Op)
+}
+
+// opError says which op of a batch failed.
+type opError struct {
+	i   int
+	err error
+}
+
+func (e opError) Error() string {
+	return "op " + strconv.Itoa(e.i) + ": " + e.err.Error()
+}
+
+func (e opError) Unwrap() error {
+	return e.err
+}
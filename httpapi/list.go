@@ -0,0 +1,194 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 100
+	MAX_PAGE_SIZE     = 1000
+	// NDJSON_FLUSH is how many lines of a stream are sent at once.
+	NDJSON_FLUSH = 100
+)
+
+type pair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type listResponse struct {
+	Pairs []pair `json:"pairs"`
+	// Next is the token of the next page, empty on the last one.
+	Next string `json:"next,omitempty"`
+}
+
+// listQuery is a parsed GET /keys.
+type listQuery struct {
+	enc     encoding
+	lo, hi  []byte // the range [lo, hi), nil for unbounded
+	reverse bool
+	limit   int // 0 for no limit, only when streaming
+	stream  bool
+}
+
+// list returns the pairs of a range, given by prefix or start and end, in
+// pages of limit pairs. The token of the next page is the key it starts
+// at, encoded, so a page sees the keys committed since the previous one.
+// With format=ndjson, or an Accept of application/x-ndjson, the pairs are
+// streamed one per line instead and the limit is optional.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q, err := parseList(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var it *kv.Iterator
+	if q.reverse {
+		it = s.db.ScanReverse(q.lo, q.hi)
+	} else {
+		it = s.db.Scan(q.lo, q.hi)
+	}
+	defer it.Close()
+	if q.stream {
+		stream(w, it, q)
+		return
+	}
+
+	resp := listResponse{Pairs: []pair{}}
+	for ; it.Valid() && len(resp.Pairs) < q.limit; it.Next() {
+		resp.Pairs = append(resp.Pairs, pair{Key: q.enc.encode(it.Key()), Value: q.enc.encode(it.Value())})
+	}
+	if err := it.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	if it.Valid() {
+		resp.Next = token(it.Key(), q.reverse)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// stream writes a line per pair. An error once the stream has started ends
+// it with an {"error": ...} line, a limit reached with a {"next": ...} one.
+func stream(w http.ResponseWriter, it *kv.Iterator, q *listQuery) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	n := 0
+	for ; it.Valid() && (q.limit == 0 || n < q.limit); it.Next() {
+		if err := enc.Encode(pair{Key: q.enc.encode(it.Key()), Value: q.enc.encode(it.Value())}); err != nil {
+			return // the client went away
+		}
+		if n++; n%NDJSON_FLUSH == 0 {
+			rc.Flush()
+		}
+	}
+	if err := it.Err(); err != nil {
+		enc.Encode(errorResponse{Error: err.Error()})
+		return
+	}
+	if it.Valid() {
+		enc.Encode(struct {
+			Next string `json:"next"`
+		}{token(it.Key(), q.reverse)})
+	}
+}
+
+func parseList(r *http.Request) (*listQuery, error) {
+	v := r.URL.Query()
+	q := &listQuery{
+		stream: v.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"),
+	}
+	var err error
+	if q.enc, err = parseEncoding(v.Get("encoding")); err != nil {
+		return nil, err
+	}
+	if v.Has("reverse") {
+		if q.reverse, err = strconv.ParseBool(v.Get("reverse")); err != nil {
+			return nil, badRequest("reverse: %v", err)
+		}
+	}
+
+	if !q.stream {
+		q.limit = DEFAULT_PAGE_SIZE
+	}
+	if v.Has("limit") {
+		q.limit, err = strconv.Atoi(v.Get("limit"))
+		if err != nil || q.limit < 1 {
+			return nil, badRequest("limit must be a positive number")
+		}
+	}
+	if !q.stream {
+		q.limit = min(q.limit, MAX_PAGE_SIZE)
+	}
+
+	if v.Has("prefix") && (v.Has("start") || v.Has("end")) {
+		return nil, badRequest("prefix can't be used with start or end")
+	}
+	param := func(name string) ([]byte, error) {
+		if !v.Has(name) {
+			return nil, nil
+		}
+		return q.enc.decode(v.Get(name))
+	}
+	if v.Has("prefix") {
+		prefix, err := param("prefix")
+		if err != nil {
+			return nil, err
+		}
+		q.lo, q.hi = prefix, kv.PrefixEnd(prefix)
+	} else {
+		if q.lo, err = param("start"); err != nil {
+			return nil, err
+		}
+		if q.hi, err = param("end"); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.Has("token") {
+		key, err := parseToken(v.Get("token"), q.reverse)
+		if err != nil {
+			return nil, err
+		}
+		// the page starts at key, which narrows the range
+		if !q.reverse && bytes.Compare(key, q.lo) > 0 {
+			q.lo = key
+		}
+		if after := append(key, 0); q.reverse && (q.hi == nil || bytes.Compare(after, q.hi) < 0) {
+			q.hi = after
+		}
+	}
+	return q, nil
+}
+
+// token encodes the key the next page starts at with the direction of the
+// scan, a token can't be used to go the other way.
+func token(key []byte, reverse bool) string {
+	dir := byte('f')
+	if reverse {
+		dir = 'r'
+	}
+	return base64.RawURLEncoding.EncodeToString(append([]byte{dir}, key...))
+}
+
+func parseToken(s string, reverse bool) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	dir := byte('f')
+	if reverse {
+		dir = 'r'
+	}
+	if err != nil || len(b) == 0 || b[0] != dir {
+		return nil, badRequest("bad token")
+	}
+	return b[1:], nil
+}
@@ -0,0 +1,146 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// page gets a page of GET /keys.
+func page(t *testing.T, base string, query url.Values) listResponse {
+	t.Helper()
+	resp, body := do(t, "GET", base+"/keys?"+query.Encode(), nil)
+	expect(t, resp, body, http.StatusOK)
+	var lr listResponse
+	if err := json.Unmarshal([]byte(body), &lr); err != nil {
+		t.Fatal(err)
+	}
+	return lr
+}
+
+// listAll follows the tokens to the last page.
+func listAll(t *testing.T, base string, query url.Values) []string {
+	t.Helper()
+	var keys []string
+	for {
+		lr := page(t, base, query)
+		for _, p := range lr.Pairs {
+			keys = append(keys, p.Key)
+		}
+		if lr.Next == "" {
+			return keys
+		}
+		query.Set("token", lr.Next)
+	}
+}
+
+func TestList(t *testing.T) {
+	_, db, base := newServer(t)
+	for i := range 250 {
+		if err := db.Insert(fmt.Appendf(nil, "user:%03d", i), fmt.Appendf(nil, "%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(fmt.Appendf(nil, "item:%03d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lr := page(t, base, url.Values{})
+	if len(lr.Pairs) != DEFAULT_PAGE_SIZE || lr.Pairs[0].Key != "item:000" || lr.Next == "" {
+		t.Fatalf("first page: %d pairs, next %q", len(lr.Pairs), lr.Next)
+	}
+	if keys := listAll(t, base, url.Values{"limit": {"33"}}); len(keys) != 500 || keys[250] != "user:000" {
+		t.Fatalf("listed %d keys", len(keys))
+	}
+
+	keys := listAll(t, base, url.Values{"prefix": {"user:"}, "reverse": {"true"}, "limit": {"40"}})
+	if len(keys) != 250 || keys[0] != "user:249" || keys[249] != "user:000" {
+		t.Fatalf("reverse prefix: %d keys from %v", len(keys), keys[:1])
+	}
+	keys = listAll(t, base, url.Values{"start": {"item:100"}, "end": {"item:110"}, "limit": {"3"}})
+	if len(keys) != 10 || keys[0] != "item:100" || keys[9] != "item:109" {
+		t.Fatalf("range: %v", keys)
+	}
+	keys = listAll(t, base, url.Values{"start": {"item:100"}, "end": {"item:110"}, "limit": {"3"}, "reverse": {"1"}})
+	if len(keys) != 10 || keys[0] != "item:109" || keys[9] != "item:100" {
+		t.Fatalf("reverse range: %v", keys)
+	}
+
+	// keys written between pages are seen by the next page
+	lr = page(t, base, url.Values{"prefix": {"user:"}, "limit": {"100"}})
+	if err := db.Insert([]byte("user:100a"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	lr = page(t, base, url.Values{"prefix": {"user:"}, "limit": {"2"}, "token": {lr.Next}})
+	if lr.Pairs[0].Key != "user:100" || lr.Pairs[1].Key != "user:100a" {
+		t.Fatalf("next page: %v", lr.Pairs)
+	}
+
+	lr = page(t, base, url.Values{"prefix": {"7573"}, "encoding": {"hex"}, "limit": {"1"}})
+	if lr.Pairs[0].Key != "757365723a303030" || lr.Pairs[0].Value != "30" {
+		t.Fatalf("hex: %v", lr.Pairs)
+	}
+	if lr = page(t, base, url.Values{"prefix": {"nope"}}); len(lr.Pairs) != 0 || lr.Next != "" {
+		t.Fatalf("empty range: %v", lr)
+	}
+
+	forward := page(t, base, url.Values{"limit": {"1"}}).Next
+	for _, bad := range []url.Values{
+		{"prefix": {"a"}, "start": {"b"}},
+		{"limit": {"0"}},
+		{"limit": {"x"}},
+		{"reverse": {"maybe"}},
+		{"token": {"!!"}},
+		{"token": {forward}, "reverse": {"true"}},
+		{"encoding": {"hex"}, "start": {"xyz"}},
+	} {
+		resp, body := do(t, "GET", base+"/keys?"+bad.Encode(), nil)
+		expect(t, resp, body, http.StatusBadRequest)
+	}
+}
+
+func TestListNDJSON(t *testing.T) {
+	_, db, base := newServer(t)
+	for i := range 2500 {
+		if err := db.Insert(fmt.Appendf(nil, "k%04d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := func(resp *http.Response, body string) []map[string]string {
+		t.Helper()
+		expect(t, resp, body, http.StatusOK)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("unexpected content type %s", ct)
+		}
+		var out []map[string]string
+		sc := bufio.NewScanner(strings.NewReader(body))
+		for sc.Scan() {
+			var m map[string]string
+			if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+				t.Fatalf("line %q: %v", sc.Text(), err)
+			}
+			out = append(out, m)
+		}
+		return out
+	}
+
+	// no page size limit when streaming
+	all := lines(do(t, "GET", base+"/keys?format=ndjson", nil))
+	if len(all) != 2500 || all[0]["key"] != "k0000" || all[2499]["key"] != "k2499" {
+		t.Fatalf("streamed %d lines", len(all))
+	}
+
+	some := lines(do(t, "GET", base+"/keys?limit=10&reverse=true", nil, "Accept", "application/x-ndjson"))
+	if len(some) != 11 || some[0]["key"] != "k2499" || some[10]["next"] == "" {
+		t.Fatalf("streamed %v", some)
+	}
+	rest := lines(do(t, "GET", base+"/keys?format=ndjson&reverse=true&token="+some[10]["next"], nil))
+	if len(rest) != 2490 || rest[0]["key"] != "k2489" {
+		t.Fatalf("rest of the stream: %d lines from %v", len(rest), rest[0])
+	}
+}
@@ -0,0 +1,168 @@
+// Package httpapi serves a kv.KV over HTTP with JSON bodies:
+//
+//	GET    /keys/{key}  the value, as is, with its version as the ETag
+//	PUT    /keys/{key}  sets the value to the body
+//	DELETE /keys/{key}
+//	GET    /keys        lists a range of pairs a page at a time, or streams
+//	                    it as NDJSON
+//	POST   /batch       puts and deletes keys in one transaction
+//	GET    /stats
+//
+// PUT and DELETE take an If-Match header with the version the key must
+// still be at, PUT also If-None-Match: * to only create the key.
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+const (
+	DEFAULT_MAX_VALUE_SIZE = 64 << 20
+	DEFAULT_MAX_BATCH_SIZE = 64 << 20
+)
+
+// Server is an http.Handler for a store, which stays owned by the caller.
+type Server struct {
+	// MaxValueSize is the biggest value a PUT may send, bigger ones get
+	// 413. The value is held in memory until it is written. Defaults to
+	// DEFAULT_MAX_VALUE_SIZE.
+	MaxValueSize int64
+	// MaxBatchSize is the biggest body of a batch. Defaults to
+	// DEFAULT_MAX_BATCH_SIZE.
+	MaxBatchSize int64
+
+	db  *kv.KV
+	mux *http.ServeMux
+}
+
+func NewServer(db *kv.KV) *Server {
+	s := &Server{
+		MaxValueSize: DEFAULT_MAX_VALUE_SIZE,
+		MaxBatchSize: DEFAULT_MAX_BATCH_SIZE,
+		db:           db,
+		mux:          http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /keys/{key...}", s.getKey)
+	s.mux.HandleFunc("PUT /keys/{key...}", s.putKey)
+	s.mux.HandleFunc("DELETE /keys/{key...}", s.deleteKey)
+	s.mux.HandleFunc("GET /keys", s.list)
+	s.mux.HandleFunc("POST /batch", s.batch)
+	s.mux.HandleFunc("GET /stats", s.stats)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// errBadRequest wraps what's wrong with a request, it gets 400.
+var errBadRequest = errors.New("bad request")
+
+// errStale is a write whose If-Match or If-None-Match doesn't hold anymore.
+var errStale = errors.New("the key changed since the version given")
+
+func badRequest(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errBadRequest, fmt.Sprintf(format, args...))
+}
+
+// status maps the errors of the store to a status code.
+func status(err error) int {
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBadRequest), errors.Is(err, kv.ErrEmptyKey):
+		return http.StatusBadRequest
+	case errors.Is(err, kv.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, kv.ErrKeyTooLarge), errors.Is(err, kv.ErrValTooLarge), errors.As(err, &tooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, kv.ErrConflict), errors.Is(err, errStale):
+		return http.StatusConflict
+	case errors.Is(err, kv.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, kv.ErrClosed):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, status(err), errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// encoding is how keys and values are written in JSON and in the query,
+// given by the encoding parameter. raw, the default, can't carry bytes
+// that aren't valid UTF-8.
+type encoding string
+
+func parseEncoding(s string) (encoding, error) {
+	switch s {
+	case "", "raw":
+		return "raw", nil
+	case "hex", "base64":
+		return encoding(s), nil
+	}
+	return "", badRequest("unknown encoding %q, want raw, hex or base64", s)
+}
+
+func (e encoding) encode(b []byte) string {
+	switch e {
+	case "hex":
+		return hex.EncodeToString(b)
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (e encoding) decode(s string) ([]byte, error) {
+	var b []byte
+	var err error
+	switch e {
+	case "hex":
+		b, err = hex.DecodeString(s)
+	case "base64":
+		b, err = base64.StdEncoding.DecodeString(s)
+	default:
+		b = []byte(s)
+	}
+	if err != nil {
+		return nil, badRequest("%s: %v", e, err)
+	}
+	return b, nil
+}
+
+type statsResponse struct {
+	Version      uint64 `json:"version"`
+	Pages        uint64 `json:"pages"`
+	FreePages    uint64 `json:"free_pages"`
+	Size         int64  `json:"size"`
+	Height       int    `json:"height"`
+	Snapshots    int    `json:"snapshots"`
+	Transactions int    `json:"transactions"`
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	st, err := s.db.Stats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statsResponse(*st))
+}
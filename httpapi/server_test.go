@@ -0,0 +1,270 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+func newServer(t *testing.T) (*Server, *kv.KV, string) {
+	t.Helper()
+	db, err := kv.Open(filepath.Join(t.TempDir(), "http.db"), &kv.Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		db.Close()
+	})
+	return s, db, ts.URL
+}
+
+// do sends a request and returns the response with its body read.
+func do(t *testing.T, method, url string, body io.Reader, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func expect(t *testing.T, resp *http.Response, body string, code int) {
+	t.Helper()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: expected %d got %d %s", resp.Request.Method, resp.Request.URL, code, resp.StatusCode, body)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s, db, url := newServer(t)
+
+	resp, body := do(t, "GET", url+"/keys/a", nil)
+	expect(t, resp, body, http.StatusNotFound)
+	if !strings.Contains(body, `"error"`) {
+		t.Fatalf("expected a JSON error got %q", body)
+	}
+
+	resp, body = do(t, "PUT", url+"/keys/a/b", strings.NewReader("hello"))
+	expect(t, resp, body, http.StatusNoContent)
+	resp, body = do(t, "GET", url+"/keys/a/b", nil)
+	expect(t, resp, body, http.StatusOK)
+	if body != "hello" {
+		t.Fatalf("expected hello got %q", body)
+	}
+	_, version, _ := db.GetVersion([]byte("a/b"))
+	if tag := resp.Header.Get("ETag"); tag != etag(version) {
+		t.Fatalf("expected ETag %s got %s", etag(version), tag)
+	}
+
+	// ranges and conditional GETs come from http.ServeContent
+	resp, body = do(t, "GET", url+"/keys/a/b", nil, "Range", "bytes=1-3")
+	expect(t, resp, body, http.StatusPartialContent)
+	if body != "ell" {
+		t.Fatalf("expected ell got %q", body)
+	}
+	resp, body = do(t, "GET", url+"/keys/a/b", nil, "If-None-Match", etag(version))
+	expect(t, resp, body, http.StatusNotModified)
+
+	// keys that aren't text
+	resp, body = do(t, "PUT", url+"/keys/00ff?encoding=hex", strings.NewReader("bin"))
+	expect(t, resp, body, http.StatusNoContent)
+	if v, err := db.Get([]byte{0, 0xff}); err != nil || string(v) != "bin" {
+		t.Fatalf("get 00ff: %q %v", v, err)
+	}
+	resp, body = do(t, "GET", url+"/keys/zz?encoding=hex", nil)
+	expect(t, resp, body, http.StatusBadRequest)
+	resp, body = do(t, "GET", url+"/keys/a?encoding=rot13", nil)
+	expect(t, resp, body, http.StatusBadRequest)
+
+	resp, body = do(t, "DELETE", url+"/keys/a/b", nil)
+	expect(t, resp, body, http.StatusNoContent)
+	resp, body = do(t, "DELETE", url+"/keys/a/b", nil)
+	expect(t, resp, body, http.StatusNotFound)
+
+	// too big
+	resp, body = do(t, "PUT", url+"/keys/"+strings.Repeat("k", 2000), strings.NewReader("v"))
+	expect(t, resp, body, http.StatusRequestEntityTooLarge)
+	s.MaxValueSize = 10
+	resp, body = do(t, "PUT", url+"/keys/big", strings.NewReader(strings.Repeat("v", 11)))
+	expect(t, resp, body, http.StatusRequestEntityTooLarge)
+	// without a Content-Length the limit is found reading the body
+	resp, body = do(t, "PUT", url+"/keys/big", io.MultiReader(strings.NewReader(strings.Repeat("v", 11))), "If-Match", "*")
+	expect(t, resp, body, http.StatusRequestEntityTooLarge)
+	if _, err := db.Get([]byte("big")); err != kv.ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+// TestSlowBody makes sure a client that is slow to send a value doesn't
+// hold up the other writes.
+func TestSlowBody(t *testing.T) {
+	_, db, url := newServer(t)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("PUT", url+"/keys/slow", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = 100_000
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	if _, err := pw.Write(make([]byte, 10_000)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // the handler is waiting for the rest
+
+	inserted := make(chan error, 1)
+	go func() { inserted <- db.Insert([]byte("other"), []byte("v")) }()
+	select {
+	case err := <-inserted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		pw.CloseWithError(errors.New("gave up"))
+		<-done
+		t.Fatal("a write waited for the body of another request")
+	}
+
+	pw.Write(make([]byte, 90_000))
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("slow")); err != nil || len(v) != 100_000 {
+		t.Fatalf("slow: %d bytes %v", len(v), err)
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	_, db, url := newServer(t)
+
+	resp, body := do(t, "PUT", url+"/keys/k", strings.NewReader("1"), "If-Match", "*")
+	expect(t, resp, body, http.StatusConflict)
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("1"), "If-None-Match", "*")
+	expect(t, resp, body, http.StatusNoContent)
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("2"), "If-None-Match", "*")
+	expect(t, resp, body, http.StatusConflict)
+
+	resp, _ = do(t, "GET", url+"/keys/k", nil)
+	tag := resp.Header.Get("ETag")
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("2"), "If-Match", tag)
+	expect(t, resp, body, http.StatusNoContent)
+	// the version moved on
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("3"), "If-Match", tag)
+	expect(t, resp, body, http.StatusConflict)
+	resp, body = do(t, "DELETE", url+"/keys/k", nil, "If-Match", tag)
+	expect(t, resp, body, http.StatusConflict)
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("3"), "If-Match", "*")
+	expect(t, resp, body, http.StatusNoContent)
+	if v, _ := db.Get([]byte("k")); string(v) != "3" {
+		t.Fatalf("expected 3 got %q", v)
+	}
+
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("4"), "If-Match", `"x"`)
+	expect(t, resp, body, http.StatusBadRequest)
+	resp, body = do(t, "PUT", url+"/keys/k", strings.NewReader("4"), "If-Match", "*", "If-None-Match", "*")
+	expect(t, resp, body, http.StatusBadRequest)
+
+	resp, _ = do(t, "GET", url+"/keys/k", nil)
+	resp, body = do(t, "DELETE", url+"/keys/k", nil, "If-Match", resp.Header.Get("ETag"))
+	expect(t, resp, body, http.StatusNoContent)
+}
+
+func TestBatch(t *testing.T) {
+	_, db, url := newServer(t)
+	if err := db.Insert([]byte("old"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := do(t, "POST", url+"/batch", strings.NewReader(`{"ops": [
+		{"op": "put", "key": "a", "value": "1"},
+		{"op": "put", "key": "b", "value": "2"},
+		{"op": "delete", "key": "old"}
+	]}`))
+	expect(t, resp, body, http.StatusOK)
+	var br batchResponse
+	if err := json.Unmarshal([]byte(body), &br); err != nil || br.Applied != 3 {
+		t.Fatalf("batch response %q %v", body, err)
+	}
+	if v, _ := db.Get([]byte("b")); string(v) != "2" {
+		t.Fatalf("expected 2 got %q", v)
+	}
+
+	// all or nothing
+	resp, body = do(t, "POST", url+"/batch", strings.NewReader(`{"ops": [
+		{"op": "put", "key": "c", "value": "3"},
+		{"op": "put", "key": "`+strings.Repeat("k", 2000)+`", "value": "4"}
+	]}`))
+	expect(t, resp, body, http.StatusRequestEntityTooLarge)
+	if !strings.Contains(body, "op 1") {
+		t.Fatalf("expected the failing op in %q", body)
+	}
+	if _, err := db.Get([]byte("c")); err != kv.ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	resp, body = do(t, "POST", url+"/batch", strings.NewReader(`{"encoding": "base64", "ops": [{"op": "put", "key": "AP8=", "value": "AQI="}]}`))
+	expect(t, resp, body, http.StatusOK)
+	if v, _ := db.Get([]byte{0, 0xff}); !bytes.Equal(v, []byte{1, 2}) {
+		t.Fatalf("expected 0102 got %x", v)
+	}
+
+	for _, bad := range []string{
+		`{"ops": [{"op": "incr", "key": "a"}]}`,
+		`{"ops": [{"op": "put", "key": "", "value": "1"}]}`,
+		`{"opps": []}`,
+		`not json`,
+	} {
+		resp, body = do(t, "POST", url+"/batch", strings.NewReader(bad))
+		expect(t, resp, body, http.StatusBadRequest)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, db, url := newServer(t)
+	if err := db.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := do(t, "GET", url+"/stats", nil)
+	expect(t, resp, body, http.StatusOK)
+	var st statsResponse
+	if err := json.Unmarshal([]byte(body), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Version != 2 || st.Height != 1 || st.Size < int64(st.Pages)*4096 {
+		t.Fatalf("unexpected stats %s", body)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+}
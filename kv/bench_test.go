@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+const benchKeys = 100_000
+
+func benchKey(i int) []byte {
+	return fmt.Appendf(nil, "key_%08d", i)
+}
+
+// newBenchDB opens a store without fsyncs, the benchmarks measure the
+// tree and the commit path rather than the disk
+func newBenchDB(b *testing.B, n int) *KV {
+	b.Helper()
+	kv, err := Open(filepath.Join(b.TempDir(), "bench.db"), &Options{NoSync: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { kv.Close() })
+
+	for start := 0; start < n; start += 1000 {
+		tx, err := kv.Begin()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := start; i < min(start+1000, n); i++ {
+			if err := tx.Set(benchKey(i), benchKey(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return kv
+}
+
+func BenchmarkGet(b *testing.B) {
+	kv := newBenchDB(b, benchKeys)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := kv.Get(benchKey(rand.IntN(benchKeys))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertSequential(b *testing.B) {
+	kv := newBenchDB(b, 0)
+	b.ResetTimer()
+	for i := range b.N {
+		if err := kv.Insert(benchKey(i), benchKey(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertRandom(b *testing.B) {
+	kv := newBenchDB(b, 0)
+	b.ResetTimer()
+	for range b.N {
+		k := benchKey(rand.IntN(benchKeys))
+		if err := kv.Insert(k, k); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	kv := newBenchDB(b, b.N)
+	b.ResetTimer()
+	for _, i := range rand.Perm(b.N) {
+		if err := kv.Delete(benchKey(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	kv := newBenchDB(b, benchKeys)
+	b.ResetTimer()
+	it := kv.Scan(nil, nil)
+	for range b.N {
+		if !it.Valid() {
+			it.Close()
+			it = kv.Scan(nil, nil)
+		}
+		_ = it.Value()
+		it.Next()
+	}
+	it.Close()
+}
@@ -0,0 +1,136 @@
+package kv
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/GiorgosMarga/my_db/btree"
+	"golang.org/x/sys/unix"
+)
+
+// PutReader sets k to the size bytes read from r, in one commit. Values too
+// big for a leaf are streamed into overflow pages that go to the file as
+// they fill up, only one page is held in memory at a time.
+//
+// The pages are allocated as r is read, so PutReader holds the writer lock
+// until r has given all of its bytes: every other write, transaction
+// commit and Vacuum waits for it meanwhile. A slow or stalled r, e.g. the
+// body of a network request, stalls them all; give it a deadline, or read
+// such a value into memory first and use Insert.
+func (kv *KV) PutReader(k []byte, r io.Reader, size int64) error {
+	if kv.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if size < 0 || uint64(size) > btree.BTREE_MAX_OVERFLOW_SIZE {
+		return fmt.Errorf("%w: %d bytes", ErrValTooLarge, size)
+	}
+	if size <= btree.BTREE_MAX_VAL_SIZE {
+		v := make([]byte, size)
+		if _, err := io.ReadFull(r, v); err != nil {
+			return fmt.Errorf("read value: %w", err)
+		}
+		return kv.Insert(k, v)
+	}
+	if err := btree.CheckSize(k, nil); err != nil {
+		return err
+	}
+
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	return kv.commit([][]byte{k}, func() (bool, error) {
+		ref, err := kv.writeOverflow(r, uint64(size))
+		if err != nil {
+			return false, err
+		}
+		return true, kv.tree.Update(&UpdateReq{
+			Key:      k,
+			Val:      ref,
+			Mode:     MODE_UPSERT,
+			Version:  kv.version + 1,
+			Overflow: true,
+			NoOld:    true,
+		})
+	})
+}
+
+// writeOverflow copies size bytes of r into a chain of overflow pages and
+// returns the reference to it. A page is written once the next one is
+// allocated and can be linked to. They are free pages no commit refers to,
+// writing them ahead of the commit is safe and reverting forgets them.
+func (kv *KV) writeOverflow(r io.Reader, size uint64) ([]byte, error) {
+	var first, ptr uint64
+	var data []byte
+	for remaining := size; remaining > 0; {
+		n := min(remaining, btree.OVERFLOW_DATA_SIZE)
+		next := make([]byte, n)
+		if _, err := io.ReadFull(r, next); err != nil {
+			return nil, fmt.Errorf("read value: %w", err)
+		}
+		nextPtr, err := kv.pageReserve()
+		if err != nil {
+			return nil, err
+		}
+
+		if data == nil {
+			first = nextPtr
+		} else if err := kv.writePage(ptr, btree.OverflowPage(nextPtr, data)); err != nil {
+			return nil, err
+		}
+		ptr, data = nextPtr, next
+		remaining -= n
+	}
+	if err := kv.writePage(ptr, btree.OverflowPage(0, data)); err != nil {
+		return nil, err
+	}
+	return btree.OverflowRef(first, size), nil
+}
+
+// pageReserve hands out a page like pageAlloc but leaves writing it to the
+// caller.
+func (kv *KV) pageReserve() (uint64, error) {
+	ptr, err := kv.freelist.PopHead()
+	if err != nil || ptr != 0 {
+		return ptr, err
+	}
+	ptr = kv.pages.flushed + kv.pages.nappend
+	kv.pages.nappend++
+	return ptr, nil
+}
+
+func (kv *KV) writePage(ptr uint64, page []byte) error {
+	setChecksum(page)
+	if _, err := unix.Pwrite(kv.fd, page, int64(ptr*btree.BNODE_PAGE_SIZE)); err != nil {
+		return fmt.Errorf("write pages: %w", err)
+	}
+	return nil
+}
+
+// ValueReader reads a committed value in place, see OpenReader.
+type ValueReader struct {
+	*btree.ValueReader
+	tx *ReadTx
+}
+
+// OpenReader returns a reader over the committed value of k that reads
+// its pages lazily from the mmap. It holds a snapshot until Close.
+func (kv *KV) OpenReader(k []byte) (*ValueReader, error) {
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return nil, err
+	}
+	r, err := tx.tree.NewValueReader(k)
+	if err != nil {
+		tx.End()
+		return nil, err
+	}
+	return &ValueReader{ValueReader: r, tx: tx}, nil
+}
+
+// Close releases the snapshot, the reader can't be used after it.
+func (r *ValueReader) Close() error {
+	r.tx.End()
+	return nil
+}
+
+var _ io.ReadSeekCloser = (*ValueReader)(nil)
@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPutReader(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "blob.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	blob := make([]byte, 5<<20+123)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range blob {
+		blob[i] = byte(rng.Uint32())
+	}
+	if err := kv.PutReader([]byte("blob"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.PutReader([]byte("small"), bytes.NewReader([]byte("tiny")), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := kv.OpenReader([]byte("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(blob)) {
+		t.Fatalf("expected %d bytes got %d", len(blob), r.Size())
+	}
+	if _, version, _ := kv.GetVersion([]byte("blob")); r.Version() != version {
+		t.Fatalf("expected version %d got %d", version, r.Version())
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("read back %d bytes %v", len(got), err)
+	}
+
+	for _, off := range []int64{int64(len(blob)) - 100, 4079, 4080, 12345, 0} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		n, err := io.ReadFull(r, buf)
+		if err != nil || !bytes.Equal(buf[:n], blob[off:off+100]) {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+	}
+
+	small, err := kv.OpenReader([]byte("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := io.ReadAll(small); err != nil || string(v) != "tiny" {
+		t.Fatalf("small: got %s %v", v, err)
+	}
+	small.Close()
+
+	// a reader that ends early commits nothing
+	err = kv.PutReader([]byte("short"), bytes.NewReader(blob[:10_000]), 20_000)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF got %v", err)
+	}
+	if _, err := kv.Get([]byte("short")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if _, err := kv.OpenReader([]byte("short")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	// Get puts the value together in one slice
+	v, err := kv.Get([]byte("blob"))
+	if err != nil || !bytes.Equal(v, blob) {
+		t.Fatalf("get: %d bytes %v", len(v), err)
+	}
+}
+
+// TestBlobNoOld makes sure replacing or deleting a big value doesn't read
+// it back into memory.
+func TestBlobNoOld(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "noold.db"), &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	const size = 8 << 20
+	allocated := func(f func() error) uint64 {
+		t.Helper()
+		if err := kv.PutReader([]byte("blob"), io.LimitReader(zeros{}, size), size); err != nil {
+			t.Fatal(err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	for name, f := range map[string]func() error{
+		"insert": func() error { return kv.Insert([]byte("blob"), []byte("small")) },
+		"delete": func() error { return kv.Delete([]byte("blob")) },
+		"tx": func() error {
+			tx, err := kv.Begin()
+			if err != nil {
+				return err
+			}
+			tx.Delete([]byte("blob"))
+			return tx.Commit()
+		},
+	} {
+		if n := allocated(f); n > size/4 {
+			t.Fatalf("%s allocated %d bytes to replace a value of %d", name, n, size)
+		}
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
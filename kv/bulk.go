@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+var ErrUnsorted = btree.ErrUnsorted
+
+// PairSource yields the pairs to bulk load. Next returns io.EOF after the
+// last one, the slices it returns may be reused by the next call.
+type PairSource interface {
+	Next() (key, val []byte, err error)
+}
+
+// BulkOptions configures BulkLoad.
+type BulkOptions struct {
+	// Sort sorts the pairs with an external merge sort first, otherwise
+	// they must come in increasing key order.
+	Sort bool
+	// TempDir holds the sorted runs that don't fit in memory. Defaults to
+	// os.TempDir.
+	TempDir string
+	// RunSize is how many bytes of pairs are sorted in memory before they
+	// are spilled to a run. Defaults to 64 MiB.
+	RunSize int
+}
+
+const DEFAULT_RUN_SIZE = 64 << 20
+
+// BulkLoad fills an empty store with every pair of src in one commit and
+// returns how many there were. It packs full leaves left to right and
+// builds the internal levels above them, writing each page to the file as
+// soon as it's full. Keys must be unique. The store is checked to be empty
+// before src is read, so the sort runs under the writer lock and other
+// writes wait for it.
+func (kv *KV) BulkLoad(src PairSource, opts *BulkOptions) (int, error) {
+	if kv.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	var o BulkOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	it := kv.tree.SeekGE(nil)
+	if it.Err() != nil {
+		return 0, it.Err()
+	}
+	if it.Valid() {
+		return 0, errors.New("bulk load needs an empty database")
+	}
+	if o.Sort {
+		sorted, err := sortPairs(src, o)
+		if err != nil {
+			return 0, err
+		}
+		defer sorted.Close()
+		src = sorted
+	}
+
+	n := 0
+	// it writes every key, open transactions can't commit past it
+	err := kv.commit(nil, func() (bool, error) {
+		tree := btree.Btree{New: kv.pageWrite}
+		b := tree.NewBuilder()
+		version := kv.version + 1
+		for {
+			k, v, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return false, fmt.Errorf("bulk load: %w", err)
+			}
+			if err := b.Add(k, v, version); err != nil {
+				return false, fmt.Errorf("bulk load: %w", err)
+			}
+			n++
+		}
+		root, err := b.Finish()
+		if err != nil {
+			return false, err
+		}
+		if kv.tree.Root != 0 {
+			if err := kv.tree.Del(kv.tree.Root); err != nil {
+				return false, err
+			}
+		}
+		kv.tree.Root = root
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// pageWrite allocates a page and writes it to the file right away, see
+// writeOverflow.
+func (kv *KV) pageWrite(page []byte) (uint64, error) {
+	ptr, err := kv.pageReserve()
+	if err != nil {
+		return 0, err
+	}
+	return ptr, kv.writePage(ptr, page)
+}
@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// slicePairs is a PairSource over k_i=v_i for each i of keys
+type slicePairs struct {
+	keys []int
+	pos  int
+}
+
+func (s *slicePairs) Next() ([]byte, []byte, error) {
+	if s.pos == len(s.keys) {
+		return nil, nil, io.EOF
+	}
+	i := s.keys[s.pos]
+	s.pos++
+	return fmt.Appendf(nil, "k_%07d", i), fmt.Appendf(nil, "v_%d", i), nil
+}
+
+func TestBulkLoad(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "bulk.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numOfKeys := 50_000
+	keys := make([]int, numOfKeys)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	// a transaction open across the load can't commit after it
+	tx := mustBegin(t, kv)
+	if _, err := tx.Get([]byte("anything")); !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+
+	n, err := kv.BulkLoad(&slicePairs{keys: keys}, nil)
+	if err != nil || n != numOfKeys {
+		t.Fatalf("loaded %d keys: %v", n, err)
+	}
+	if err := tx.Set([]byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict got %v", err)
+	}
+
+	if _, err := kv.BulkLoad(&slicePairs{keys: keys}, nil); err == nil {
+		t.Fatal("loaded into a database that isn't empty")
+	}
+	if err := kv.Insert([]byte("k_0000010x"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	for range 1000 {
+		i := rand.IntN(numOfKeys)
+		v, err := kv.Get(fmt.Appendf(nil, "k_%07d", i))
+		if err != nil || string(v) != fmt.Sprintf("v_%d", i) {
+			t.Fatalf("k_%07d: got %s %v", i, v, err)
+		}
+	}
+	if got := len(collect(kv.Scan(nil, nil))); got != numOfKeys+1 {
+		t.Fatalf("expected %d keys got %d", numOfKeys+1, got)
+	}
+}
+
+func TestBulkLoadUnsorted(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "unsorted.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	keys := rand.Perm(20_000)
+	if _, err := kv.BulkLoad(&slicePairs{keys: keys}, nil); !errors.Is(err, ErrUnsorted) {
+		t.Fatalf("expected ErrUnsorted got %v", err)
+	}
+	if keys := collect(kv.Scan(nil, nil)); len(keys) != 0 {
+		t.Fatalf("a failed load left %d keys behind", len(keys))
+	}
+
+	// small runs so the sort spills and merges plenty of them
+	tmp := t.TempDir()
+	n, err := kv.BulkLoad(&slicePairs{keys: keys}, &BulkOptions{Sort: true, TempDir: tmp, RunSize: 16 << 10})
+	if err != nil || n != len(keys) {
+		t.Fatalf("loaded %d keys: %v", n, err)
+	}
+	it := kv.Scan(nil, nil)
+	i := 0
+	for ; it.Valid(); it.Next() {
+		if string(it.Key()) != fmt.Sprintf("k_%07d", i) || string(it.Value()) != fmt.Sprintf("v_%d", i) {
+			t.Fatalf("expected k_%07d got %s=%s", i, it.Key(), it.Value())
+		}
+		i++
+	}
+	it.Close()
+	if i != len(keys) {
+		t.Fatalf("expected %d keys got %d", len(keys), i)
+	}
+	if runs, _ := os.ReadDir(tmp); len(runs) != 0 {
+		t.Fatalf("%d sort runs left behind", len(runs))
+	}
+
+	// a store that isn't empty is turned down before the pairs are sorted
+	again := &slicePairs{keys: keys}
+	if _, err := kv.BulkLoad(again, &BulkOptions{Sort: true, TempDir: tmp}); err == nil {
+		t.Fatal("loaded into a database that isn't empty")
+	}
+	if again.pos != 0 {
+		t.Fatalf("read %d pairs before finding the database wasn't empty", again.pos)
+	}
+}
@@ -0,0 +1,58 @@
+package kv
+
+import "bytes"
+
+// The conditional writes below check the current entry of the key and
+// write in the same pass down the tree and the same commit, nothing can
+// change the key in between. They report whether the write happened.
+
+// CompareAndSwap sets k to new only if k exists with the value old.
+func (kv *KV) CompareAndSwap(k, old, new []byte) (bool, error) {
+	req := &UpdateReq{
+		Key:  k,
+		Val:  new,
+		Mode: MODE_UPDATE_ONLY,
+		Check: func(_ bool, current []byte, _ uint64) bool {
+			return bytes.Equal(current, old)
+		},
+	}
+	err := kv.Update(req)
+	return req.Updated, err
+}
+
+// CompareAndDelete removes k only if its value is old.
+func (kv *KV) CompareAndDelete(k, old []byte) (bool, error) {
+	req := &UpdateReq{
+		Key:  k,
+		Mode: MODE_DELETE,
+		Check: func(_ bool, current []byte, _ uint64) bool {
+			return bytes.Equal(current, old)
+		},
+	}
+	err := kv.Update(req)
+	return req.Updated, err
+}
+
+// SetIfVersion sets k to v only if k is still at version, as returned by
+// GetVersion. A version of 0 only matches a missing key.
+func (kv *KV) SetIfVersion(k, v []byte, version uint64) (bool, error) {
+	req := &UpdateReq{Key: k, Val: v, Mode: MODE_UPSERT, Check: versionIs(version), NoOld: true}
+	err := kv.Update(req)
+	return req.Updated, err
+}
+
+// DeleteIfVersion removes k only if it is still at version.
+func (kv *KV) DeleteIfVersion(k []byte, version uint64) (bool, error) {
+	req := &UpdateReq{Key: k, Mode: MODE_DELETE, Check: versionIs(version), NoOld: true}
+	err := kv.Update(req)
+	return req.Updated, err
+}
+
+func versionIs(version uint64) func(bool, []byte, uint64) bool {
+	return func(exists bool, _ []byte, current uint64) bool {
+		if !exists {
+			return version == 0
+		}
+		return current == version
+	}
+}
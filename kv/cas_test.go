@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "cas.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	if ok, err := kv.CompareAndSwap([]byte("k"), nil, []byte("1")); err != nil || ok {
+		t.Fatalf("swap of a missing key: %v %v", ok, err)
+	}
+	if err := kv.Insert([]byte("k"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := kv.CompareAndSwap([]byte("k"), []byte("2"), []byte("3")); err != nil || ok {
+		t.Fatalf("swap with the wrong value: %v %v", ok, err)
+	}
+	if ok, err := kv.CompareAndSwap([]byte("k"), []byte("1"), []byte("2")); err != nil || !ok {
+		t.Fatalf("swap: %v %v", ok, err)
+	}
+	if ok, err := kv.CompareAndDelete([]byte("k"), []byte("1")); err != nil || ok {
+		t.Fatalf("delete with the wrong value: %v %v", ok, err)
+	}
+	if ok, err := kv.CompareAndDelete([]byte("k"), []byte("2")); err != nil || !ok {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if _, err := kv.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "versions.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	if ok, err := kv.SetIfVersion([]byte("lease"), []byte("a"), 0); err != nil || !ok {
+		t.Fatalf("take a missing lease: %v %v", ok, err)
+	}
+	if ok, err := kv.SetIfVersion([]byte("lease"), []byte("b"), 0); err != nil || ok {
+		t.Fatalf("take a held lease: %v %v", ok, err)
+	}
+	v, version, err := kv.GetVersion([]byte("lease"))
+	if err != nil || string(v) != "a" || version == 0 {
+		t.Fatalf("lease: got %s at %d %v", v, version, err)
+	}
+
+	// an unrelated commit doesn't move the version of the key
+	if err := kv.Insert([]byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, again, _ := kv.GetVersion([]byte("lease")); again != version {
+		t.Fatalf("expected version %d got %d", version, again)
+	}
+
+	if ok, err := kv.SetIfVersion([]byte("lease"), []byte("a2"), version); err != nil || !ok {
+		t.Fatalf("renew: %v %v", ok, err)
+	}
+	_, renewed, _ := kv.GetVersion([]byte("lease"))
+	if renewed <= version {
+		t.Fatalf("expected a version after %d got %d", version, renewed)
+	}
+	if ok, err := kv.DeleteIfVersion([]byte("lease"), version); err != nil || ok {
+		t.Fatalf("release with a stale version: %v %v", ok, err)
+	}
+	if ok, err := kv.DeleteIfVersion([]byte("lease"), renewed); err != nil || !ok {
+		t.Fatalf("release: %v %v", ok, err)
+	}
+}
+
+func TestConcurrentCAS(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "cas_counter.db"), &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if err := kv.Insert([]byte("counter"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	workers, increments := 8, 50
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				for {
+					old, err := kv.Get([]byte("counter"))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n, _ := strconv.Atoi(string(old))
+					ok, err := kv.CompareAndSwap([]byte("counter"), old, strconv.AppendInt(nil, int64(n+1), 10))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, err := kv.Get([]byte("counter")); err != nil || string(v) != strconv.Itoa(workers*increments) {
+		t.Fatalf("expected %d got %s %v", workers*increments, v, err)
+	}
+}
@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"fmt"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// Problem is something wrong with a page found by Check.
+type Problem = btree.Problem
+
+// CheckReport is what Check found, the file is healthy if it has no
+// Problems.
+type CheckReport struct {
+	Version  uint64 // of the commit that was checked
+	Pages    uint64 // in the file, the meta page included
+	Tree     int    // pages of the tree, overflow pages included
+	Keys     int
+	Free     int // pages in the freelist
+	FreeList int // pages the freelist is stored in
+	Problems []Problem
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Check verifies the last commit: the tree, see btree.Check, and the
+// freelist from its head to its tail, and that every page of the file is
+// used exactly once by one of them or the meta. Problems with the file go
+// in the report, the error is only for a store that can't be checked.
+func (kv *KV) Check() (*CheckReport, error) {
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	kv.mu.Lock()
+	closed := kv.closed
+	kv.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	r := &CheckReport{Version: kv.version, Pages: kv.pages.flushed}
+	report := func(p Problem) {
+		r.Problems = append(r.Problems, p)
+	}
+	owners := make(map[uint64]string)
+	use := func(ptr uint64, owner string) {
+		switch {
+		case ptr >= r.Pages:
+			report(Problem{Page: ptr, Reason: fmt.Sprintf("used by the %s past the end of the file at page %d", owner, r.Pages)})
+		case owners[ptr] != "":
+			report(Problem{Page: ptr, Reason: fmt.Sprintf("used by the %s and the %s", owners[ptr], owner)})
+		default:
+			owners[ptr] = owner
+		}
+	}
+
+	use(0, "meta")
+	r.Keys = kv.tree.Check(func(ptr uint64) {
+		r.Tree++
+		use(ptr, "tree")
+	}, report)
+
+	fl := &kv.freelist
+	if entries := fl.TailIdx - fl.HeadIdx; entries > r.Pages {
+		report(Problem{Page: fl.HeadPage, Reason: fmt.Sprintf("freelist of %d entries in a file of %d pages", entries, r.Pages)})
+	} else {
+		lists, err := fl.Walk(func(_, ptr uint64) error {
+			r.Free++
+			use(ptr, "freelist")
+			return nil
+		})
+		if err != nil {
+			report(Problem{Page: fl.HeadPage, Reason: fmt.Sprintf("freelist: %v", err)})
+		}
+		for _, ptr := range lists {
+			r.FreeList++
+			use(ptr, "freelist pages")
+		}
+	}
+
+	for ptr := range r.Pages {
+		if owners[ptr] == "" {
+			report(Problem{Page: ptr, Reason: "not used by anything"})
+		}
+	}
+	return r, nil
+}
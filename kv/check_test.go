@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+func mustCheck(t *testing.T, kv *KV) *CheckReport {
+	t.Helper()
+	r, err := kv.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCheck(t *testing.T) {
+	kv, _ := newVacuumDB(t)
+	defer kv.Close()
+
+	r := mustCheck(t, kv)
+	if !r.OK() {
+		t.Fatalf("healthy file: %v", r.Problems)
+	}
+	if r.Keys != 301 || uint64(1+r.Tree+r.Free+r.FreeList) != r.Pages {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if _, err := kv.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	if r := mustCheck(t, kv); !r.OK() || r.Keys != 301 {
+		t.Fatalf("after vacuum: %+v", r)
+	}
+
+	// a page taken off the freelist and never used is lost
+	kv.writer.Lock()
+	var lost uint64
+	err := kv.commit([][]byte{}, func() (bool, error) {
+		var err error
+		lost, err = kv.freelist.PopHead()
+		return true, err
+	})
+	kv.writer.Unlock()
+	if err != nil || lost == 0 {
+		t.Fatalf("pop: %d %v", lost, err)
+	}
+	r = mustCheck(t, kv)
+	if len(r.Problems) != 1 || r.Problems[0].Page != lost {
+		t.Fatalf("expected page %d to be reported got %v", lost, r.Problems)
+	}
+}
+
+func TestCheckCorrupt(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "check.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := kv.Insert([]byte(k), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := kv.tree.Root
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b becomes d, out of order, with a valid checksum
+	f, err := os.OpenFile(dbName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	page := make([]byte, btree.BNODE_PAGE_SIZE)
+	if _, err := f.ReadAt(page, int64(root)*btree.BNODE_PAGE_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(page, []byte("bv"))
+	page[i] = 'd'
+	setChecksum(page)
+	if _, err := f.WriteAt(page, int64(root)*btree.BNODE_PAGE_SIZE); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mustCheck(t, ro)
+	if len(r.Problems) != 1 || r.Problems[0].Page != root || !strings.Contains(r.Problems[0].Reason, "after") {
+		t.Fatalf("expected the order of the root to be reported got %v", r.Problems)
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a page that fails its checksum
+	page[100] ^= 1
+	if _, err := f.WriteAt(page, int64(root)*btree.BNODE_PAGE_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	ro, err = Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	r = mustCheck(t, ro)
+	if r.OK() || r.Problems[0].Page != root || !strings.Contains(fmt.Sprint(r.Problems[0]), "checksum") {
+		t.Fatalf("expected a checksum problem got %v", r.Problems)
+	}
+}
@@ -0,0 +1,39 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// CorruptPageError is returned when a page can't be read from the file,
+// usually because it doesn't match the checksum stored in its header. It
+// matches ErrCorrupt with errors.Is.
+type CorruptPageError struct {
+	Page   uint64
+	Reason string
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("page %d is corrupt: %s", e.Page, e.Reason)
+}
+
+func (e *CorruptPageError) Unwrap() error {
+	return ErrCorrupt
+}
+
+func pageChecksum(page []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, page[:btree.CHECKSUM_OFFSET])
+	crc = crc32.Update(crc, castagnoli, make([]byte, btree.CHECKSUM_SIZE))
+	return crc32.Update(crc, castagnoli, page[btree.CHECKSUM_OFFSET+btree.CHECKSUM_SIZE:])
+}
+
+func setChecksum(page []byte) {
+	binary.LittleEndian.PutUint32(page[btree.CHECKSUM_OFFSET:], pageChecksum(page))
+}
+
+func validChecksum(page []byte) bool {
+	return binary.LittleEndian.Uint32(page[btree.CHECKSUM_OFFSET:]) == pageChecksum(page)
+}
@@ -0,0 +1,81 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+func TestCorruptPage(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "corrupt.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// flip a bit in the middle of the root page
+	root := kv.tree.Root
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(dbName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	offset := int64(root)*btree.BNODE_PAGE_SIZE + 100
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0x10
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *CorruptPageError
+	_, err = reopened.Get([]byte("k_1"))
+	if !errors.As(err, &cerr) || cerr.Page != root {
+		t.Fatalf("expected corrupt page %d got %v", root, err)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt got %v", err)
+	}
+
+	it := reopened.Scan(nil, nil)
+	defer it.Close()
+	if it.Valid() || !errors.As(it.Err(), &cerr) {
+		t.Fatalf("expected scan to stop on the corrupt page, got %v", it.Err())
+	}
+
+	if err := reopened.Insert([]byte("k_1"), []byte("new")); !errors.As(err, &cerr) {
+		t.Fatalf("expected insert to fail on the corrupt page, got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	page := make([]byte, btree.BNODE_PAGE_SIZE)
+	copy(page[100:], "some content")
+	setChecksum(page)
+	if !validChecksum(page) {
+		t.Fatal("checksum should match")
+	}
+	page[200] = 1
+	if validChecksum(page) {
+		t.Fatal("checksum should not match")
+	}
+}
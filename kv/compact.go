@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// CompactStats reports what Compact did.
+type CompactStats struct {
+	Keys       int
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Compact rewrites the database at filename into a fresh file holding only
+// the live tree, laid out in key order, and swaps it into place. It takes
+// the file lock for the whole run, so nothing else may have the database
+// open, an Open waiting for the lock gets the new file. Keys keep their
+// versions.
+func Compact(filename string, opts *Options) (*CompactStats, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	o.ReadOnly, o.NoLock = false, false // exclusive lock, the file itself isn't written
+
+	before, err := os.Stat(filename) // don't create a database to compact it
+	if err != nil {
+		return nil, err
+	}
+	src, err := Open(filename, &o)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	stats := &CompactStats{SizeBefore: before.Size()}
+
+	tmp := filename + ".compact"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dst, err := Open(tmp, &Options{NoSync: true})
+	if err != nil {
+		return nil, err
+	}
+	stats.Keys, err = src.copyTo(dst)
+	err = errors.Join(err, dst.Close()) // Close syncs the file
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("compact: %w", err)
+	}
+	if err := syncDir(filename); err != nil {
+		return nil, err
+	}
+
+	after, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	stats.SizeAfter = after.Size()
+	return stats, nil
+}
+
+// copyTo fills the empty dst with the last commit of kv, dst carries on
+// from the version of kv.
+func (kv *KV) copyTo(dst *KV) (int, error) {
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.End()
+
+	n := 0
+	err = dst.fill(tx.version, func(b *btree.Builder) error {
+		it := tx.tree.SeekGE(nil)
+		for ; it.Valid(); it.Next() {
+			v := it.Value()
+			if it.Err() != nil {
+				break
+			}
+			if err := b.Add(it.Key(), v, it.Version()); err != nil {
+				return err
+			}
+			n++
+		}
+		return it.Err()
+	})
+	return n, err
+}
+
+// fill builds the tree of the empty kv bottom up in one commit out of the
+// pairs add gives the builder, in key order and with their versions. kv
+// carries on from version.
+func (kv *KV) fill(version uint64, add func(b *btree.Builder) error) error {
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	return kv.commit(nil, func() (bool, error) {
+		tree := btree.Btree{New: kv.pageWrite}
+		b := tree.NewBuilder()
+		if err := add(b); err != nil {
+			return false, err
+		}
+		root, err := b.Finish()
+		if err != nil {
+			return false, err
+		}
+		if kv.tree.Root != 0 {
+			if err := kv.tree.Del(kv.tree.Root); err != nil {
+				return false, err
+			}
+		}
+		kv.tree.Root = root
+		kv.version = max(kv.version, version)
+		return true, nil
+	})
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "compact.db")
+	kv, err := Open(dbName, &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte("big value "), 2000)
+	for i := range 5000 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%05d", i), bytes.Repeat([]byte("v"), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 5000 {
+		if i%10 == 0 {
+			continue
+		}
+		if err := kv.Delete(fmt.Appendf(nil, "k_%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Insert([]byte("big"), big); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := kv.GetVersion([]byte("k_00010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastVersion := kv.version
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Compact(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 501 {
+		t.Fatalf("expected 501 keys got %d", stats.Keys)
+	}
+	if stats.SizeAfter >= stats.SizeBefore/4 {
+		t.Fatalf("expected the file to shrink, %d -> %d", stats.SizeBefore, stats.SizeAfter)
+	}
+	if fi, err := os.Stat(dbName); err != nil || fi.Size() != stats.SizeAfter {
+		t.Fatalf("size of the compacted file: %v %v", fi, err)
+	}
+	if _, err := os.Stat(dbName + ".compact"); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file was left behind: %v", err)
+	}
+
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.version <= lastVersion {
+		t.Fatalf("version went back from %d to %d", lastVersion, reopened.version)
+	}
+	if got := collect(reopened.Scan(nil, nil)); len(got) != 501 || got[0] != "big" || got[1] != "k_00000" {
+		t.Fatalf("unexpected keys after compact: %d %v", len(got), got[:2])
+	}
+	v, got, err := reopened.GetVersion([]byte("k_00010"))
+	if err != nil || got != version || !bytes.Equal(v, bytes.Repeat([]byte("v"), 100)) {
+		t.Fatalf("k_00010: version %d (expected %d) %v", got, version, err)
+	}
+	if v, err := reopened.Get([]byte("big")); err != nil || !bytes.Equal(v, big) {
+		t.Fatalf("big: %d bytes %v", len(v), err)
+	}
+	// the meta page must never be handed out
+	_, err = reopened.freelist.Walk(func(_, ptr uint64) error {
+		if ptr == 0 {
+			t.Fatal("the meta page is in the freelist")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the compacted file takes writes like any other
+	if err := reopened.Insert([]byte("k_00001"), []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,23 @@
+package kv
+
+import (
+	"errors"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+var (
+	ErrNotFound     = btree.ErrNotFound
+	ErrKeyTooLarge  = btree.ErrKeyTooLarge
+	ErrEmptyKey     = btree.ErrEmptyKey
+	ErrValTooLarge  = btree.ErrValTooLarge
+	ErrCorrupt      = btree.ErrCorrupt
+	ErrBadSignature = errors.New("not a database file: bad signature")
+	ErrClosed       = errors.New("database is closed")
+	ErrLocked       = errors.New("database is locked by another process")
+	ErrUnknownMerge = errors.New("unknown merge operator")
+	ErrMergeOperand = errors.New("bad merge operand")
+	ErrReadOnly     = errors.New("database is open read-only")
+	ErrTxDone       = errors.New("transaction already committed or aborted")
+	ErrConflict     = errors.New("transaction conflicts with a concurrent commit, retry")
+)
@@ -3,10 +3,14 @@ package kv
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"path"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/GiorgosMarga/my_db/btree"
 	"github.com/GiorgosMarga/my_db/freelist"
@@ -15,10 +19,49 @@ import (
 
 const DB_DIG = "MY_DB_SIG_012345"
 
+const DEFAULT_MMAP_SIZE = 64 << 20
+
+// The meta is kept in two slots of page 0 written in turn, so a torn write
+// can only damage the slot that wasn't the latest one.
+// SIG | ROOT | FLUSHED | HEAD PAGE | HEAD IDX | TAIL PAGE | TAIL IDX | VERSION | CRC
+// 16b   8b     8b        8b          8b         8b          8b         8b        4b
+const (
+	META_SIZE        = 76
+	META_SLOTS       = 2
+	META_SLOT_OFFSET = btree.BNODE_PAGE_SIZE / META_SLOTS
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures Open. The zero value is a read-write store with the
+// default mmap size that syncs every commit.
+type Options struct {
+	// InitialMmapSize is the size of the first mapping made to hold new
+	// pages, later ones double it. Defaults to 64 MiB, and is rounded up to
+	// a whole number of pages since mappings start on a page.
+	InitialMmapSize int
+	// ReadOnly opens the file without ever writing to it. The file must
+	// exist and every write fails with ErrReadOnly.
+	ReadOnly bool
+	// NoSync skips the fsyncs of each commit. Commits are much cheaper but
+	// a crash can lose the latest ones, Close still syncs the file.
+	NoSync bool
+	// LockTimeout is how long Open waits for another process to release
+	// the file before failing with ErrLocked. Zero doesn't wait.
+	LockTimeout time.Duration
+	// NoLock skips the file lock. It lets a read-only store follow a writer
+	// in another process with Refresh, but that writer doesn't know about
+	// its snapshots and may reuse their pages, so reads can fail with
+	// ErrCorrupt or see later data until the next Refresh. A writer opened
+	// with NoLock never truncates the file, see Vacuum.
+	NoLock bool
+}
+
 type KV struct {
 	filename string
 	fd       int
 	failed   bool
+	opts     Options
 
 	pages struct {
 		flushed uint64
@@ -33,12 +76,118 @@ type KV struct {
 
 	tree     btree.Btree
 	freelist freelist.FreeList
+	version  uint64 // number of commits, also the sequence number of the meta
+	metaSlot int    // meta slot holding the current root
+
+	// held while a transaction applies its writes and commits
+	writer sync.Mutex
+
+	// mu guards the last committed state handed out to transactions, the
+	// list of mmap chunks they read from and the conflict bookkeeping
+	mu       sync.Mutex
+	snapshot struct {
+		root    uint64
+		tailIdx uint64
+		version uint64
+	}
+	history []commitRecord // commits that open transactions may conflict with
+	ongoing map[uint64]int // versions open read-write transactions started at
+	active  map[uint64]int // versions open snapshots, ReadTx or Tx, are at
+	closed  bool
+	readers sync.WaitGroup // open ReadTx, Close waits for them
+}
+
+// Open opens the database at filename, creating it if needed. A nil opts
+// is the same as the zero Options.
+func Open(filename string, opts *Options) (*KV, error) {
+	kv := &KV{}
+	if opts != nil {
+		kv.opts = *opts
+	}
+	if kv.opts.InitialMmapSize <= 0 {
+		kv.opts.InitialMmapSize = DEFAULT_MMAP_SIZE
+	}
+	const page = btree.BNODE_PAGE_SIZE
+	kv.opts.InitialMmapSize = (kv.opts.InitialMmapSize + page - 1) / page * page
+	if err := kv.open(filename); err != nil {
+		kv.release()
+		return nil, err
+	}
+	return kv, nil
+}
+
+// Close waits for open transactions and iterators to end, syncs the file
+// and releases the mmap and the file descriptor. Any later call returns
+// ErrClosed.
+func (kv *KV) Close() error {
+	kv.mu.Lock()
+	if kv.closed {
+		kv.mu.Unlock()
+		return ErrClosed
+	}
+	kv.closed = true
+	kv.mu.Unlock()
+
+	kv.readers.Wait()
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	var err error
+	if !kv.opts.ReadOnly {
+		err = syscall.Fsync(kv.fd)
+	}
+	return errors.Join(err, kv.release())
+}
+
+// release unmaps every chunk and closes the file
+func (kv *KV) release() error {
+	var errs []error
+	for _, chunk := range kv.mmap.chunks {
+		if err := syscall.Munmap(chunk); err != nil {
+			errs = append(errs, fmt.Errorf("munmap: %w", err))
+		}
+	}
+	kv.mmap.chunks = nil
+	kv.mmap.size = 0
+	if kv.fd > 0 {
+		if err := syscall.Close(kv.fd); err != nil {
+			errs = append(errs, fmt.Errorf("close: %w", err))
+		}
+		kv.fd = -1
+	}
+	return errors.Join(errs...)
 }
 
-func (kv *KV) Init(filename string) error {
+func (kv *KV) open(filename string) error {
 	kv.filename = filename
-	if err := kv.createFileSync(filename); err != nil {
-		return err
+	for {
+		if kv.opts.ReadOnly {
+			fd, err := syscall.Open(filename, os.O_RDONLY, 0)
+			if err != nil {
+				return fmt.Errorf("open file: %w", err)
+			}
+			kv.fd = fd
+		} else if err := kv.createFileSync(filename); err != nil {
+			return err
+		}
+		if kv.opts.NoLock {
+			break
+		}
+		if err := kv.lock(); err != nil {
+			return err
+		}
+		// Compact renames a new file over the database while it holds the
+		// lock of the old one, a lock that was waited for may be on a file
+		// that's gone
+		replaced, err := kv.replaced()
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			break
+		}
+		syscall.Close(kv.fd)
+		kv.fd = -1
 	}
 
 	stat, err := os.Stat(kv.filename)
@@ -76,40 +225,61 @@ func (kv *KV) Init(filename string) error {
 	if err := kv.readRoot(int(size)); err != nil {
 		return err
 	}
+	kv.publish()
 
 	return nil
 }
-func (kv *KV) pageUpdate(ptr uint64) []byte {
+func (kv *KV) pageUpdate(ptr uint64) ([]byte, error) {
 
 	if node, ok := kv.pages.updated[ptr]; ok {
-		return node // pending update
+		return node, nil // pending update
 	}
 
+	page, err := kv.readPageFromFile(ptr)
+	if err != nil {
+		return nil, err
+	}
 	newNode := make([]byte, btree.BNODE_PAGE_SIZE)
-	copy(newNode, kv.readPageFromFile(ptr))
+	copy(newNode, page)
 	kv.pages.updated[ptr] = newNode // initialized from file
-	return newNode
+	return newNode, nil
 }
 
-func (kv *KV) pageAlloc(data []byte) uint64 {
-	ptr := kv.freelist.PopHead()
+func (kv *KV) pageAlloc(data []byte) (uint64, error) {
+	ptr, err := kv.freelist.PopHead()
+	if err != nil {
+		return 0, err
+	}
 	if ptr != 0 {
-		// kv.pages.nappend++
 		kv.pages.updated[ptr] = data
-		return ptr
+		return ptr, nil
 	}
 	return kv.appendPage(data)
 }
 
-func (kv *KV) pageRead(ptr uint64) []byte {
+func (kv *KV) pageRead(ptr uint64) ([]byte, error) {
 	// read from updated map means the page was re-used
 	if n, ok := kv.pages.updated[ptr]; ok {
-		return n
+		return n, nil
 	}
 	// read from mmap
 	return kv.readPageFromFile(ptr)
 }
 
+// syncDir makes the entries of the directory of filename durable, e.g. a
+// file that was just created or renamed.
+func syncDir(filename string) error {
+	fd, err := syscall.Open(path.Dir(filename), os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return fmt.Errorf("open folder: %w", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Fsync(fd); err != nil {
+		return fmt.Errorf("folder sync: %w", err)
+	}
+	return nil
+}
+
 func (kv *KV) createFileSync(filename string) error {
 	flags := os.O_RDONLY | syscall.O_DIRECTORY
 
@@ -136,28 +306,80 @@ func (kv *KV) createFileSync(filename string) error {
 
 }
 
-func (kv *KV) Insert(k, v []byte) error {
-	prevMeta := kv.createMeta()
-	if err := kv.tree.Insert(k, v); err != nil {
-		return err // invalid k or v length
+// UpdateReq is a single write, see KV.Update.
+type UpdateReq = btree.UpdateReq
+
+const (
+	MODE_UPSERT      = btree.MODE_UPSERT
+	MODE_UPDATE_ONLY = btree.MODE_UPDATE_ONLY
+	MODE_INSERT_ONLY = btree.MODE_INSERT_ONLY
+	MODE_DELETE      = btree.MODE_DELETE
+)
+
+// Update applies req as a commit of its own and fills in whether the key
+// existed and, unless NoOld is set, the value it had. Old is a copy owned
+// by the caller. A write the mode doesn't allow leaves Updated false and
+// commits nothing.
+func (kv *KV) Update(req *UpdateReq) error {
+	if kv.opts.ReadOnly {
+		return ErrReadOnly
 	}
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	return kv.commit([][]byte{req.Key}, func() (bool, error) {
+		req.Version = kv.version + 1 // the version of this commit
+		err := kv.tree.Update(req)
+		if !req.NoOld {
+			req.Old = bytes.Clone(req.Old)
+		}
+		return req.Updated, err
+	})
+}
 
-	return kv.updateOrRevert(prevMeta)
+func (kv *KV) Insert(k, v []byte) error {
+	return kv.Update(&UpdateReq{Key: k, Val: v, Mode: MODE_UPSERT, NoOld: true})
 }
 
+// Get returns a copy of the committed value of k.
 func (kv *KV) Get(k []byte) ([]byte, error) {
-	return kv.tree.GetValue(k)
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.End()
+
+	v, err := tx.Get(k)
+	return bytes.Clone(v), err
 }
 
-func (kv *KV) Delete(k []byte) error {
-	return kv.tree.Delete(k)
+// GetVersion returns a copy of the committed value of k and the version of
+// the commit that wrote it, to be used with SetIfVersion.
+func (kv *KV) GetVersion(k []byte) ([]byte, uint64, error) {
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.End()
+
+	v, version, err := tx.GetVersion(k)
+	return bytes.Clone(v), version, err
 }
-func (kv *KV) loadMeta(meta []byte) {
 
-	if !bytes.Equal(meta[:16], []byte(DB_DIG)) {
-		panic("wrong sig")
+// Delete removes k, it returns ErrNotFound if k isn't there.
+func (kv *KV) Delete(k []byte) error {
+	req := &UpdateReq{Key: k, Mode: MODE_DELETE, NoOld: true}
+	if err := kv.Update(req); err != nil {
+		return err
+	}
+	if !req.Existed {
+		return ErrNotFound
 	}
+	return nil
+}
 
+// loadMeta expects a meta created by createMeta or checked by validMeta
+func (kv *KV) loadMeta(meta []byte) {
 	kv.tree.Root = binary.LittleEndian.Uint64(meta[16:])
 
 	kv.pages.flushed = binary.LittleEndian.Uint64(meta[24:])
@@ -168,11 +390,13 @@ func (kv *KV) loadMeta(meta []byte) {
 	kv.freelist.TailPage = binary.LittleEndian.Uint64(meta[48:])
 	kv.freelist.TailIdx = binary.LittleEndian.Uint64(meta[56:])
 
+	kv.version = binary.LittleEndian.Uint64(meta[64:])
+
 	kv.freelist.SetMaxIdx()
 }
 
 func (kv *KV) createMeta() []byte {
-	meta := make([]byte, 64)
+	meta := make([]byte, META_SIZE)
 	copy(meta[0:], []byte(DB_DIG))
 	binary.LittleEndian.PutUint64(meta[16:], kv.tree.Root)
 
@@ -182,15 +406,35 @@ func (kv *KV) createMeta() []byte {
 	binary.LittleEndian.PutUint64(meta[40:], kv.freelist.HeadIdx)
 	binary.LittleEndian.PutUint64(meta[48:], kv.freelist.TailPage)
 	binary.LittleEndian.PutUint64(meta[56:], kv.freelist.TailIdx)
+	binary.LittleEndian.PutUint64(meta[64:], kv.version)
+	binary.LittleEndian.PutUint32(meta[72:], crc32.Checksum(meta[:72], castagnoli))
 	return meta
 }
 
+// validMeta returns the version of the meta in slot, ErrBadSignature if
+// the slot was never written and ErrCorrupt if it was torn.
+func validMeta(slot []byte) (uint64, error) {
+	if !bytes.Equal(slot[:16], []byte(DB_DIG)) {
+		return 0, ErrBadSignature
+	}
+	if binary.LittleEndian.Uint32(slot[72:]) != crc32.Checksum(slot[:72], castagnoli) {
+		return 0, fmt.Errorf("%w: meta checksum mismatch", ErrCorrupt)
+	}
+	return binary.LittleEndian.Uint64(slot[64:]), nil
+}
+
+// MetaSlot returns the meta slot holding the current root. Right after
+// Open it is the slot readRoot picked as the newest valid one.
+func (kv *KV) MetaSlot() int {
+	return kv.metaSlot
+}
+
 func (kv *KV) extendMMap(size uint64) error {
 	if size <= kv.mmap.size {
 		return nil
 	}
 
-	alloc := max(kv.mmap.size, 64<<20)
+	alloc := max(kv.mmap.size, uint64(kv.opts.InitialMmapSize))
 
 	for size > kv.mmap.size+alloc {
 		alloc *= 2
@@ -201,7 +445,9 @@ func (kv *KV) extendMMap(size uint64) error {
 		return fmt.Errorf("mmap: %w", err)
 	}
 
+	kv.mu.Lock()
 	kv.mmap.chunks = append(kv.mmap.chunks, chunk)
+	kv.mu.Unlock()
 	kv.mmap.size += alloc
 
 	return nil
@@ -215,6 +461,7 @@ func (kv *KV) writePages() error {
 	}
 
 	for ptr, data := range kv.pages.updated {
+		setChecksum(data)
 		offset := ptr * btree.BNODE_PAGE_SIZE
 		if _, err := unix.Pwrite(kv.fd, data, int64(offset)); err != nil {
 			return fmt.Errorf("write pages: %w", err)
@@ -227,25 +474,33 @@ func (kv *KV) writePages() error {
 	return nil
 }
 
-func (kv *KV) readPageFromFile(ptr uint64) []byte {
+func (kv *KV) readPageFromFile(ptr uint64) ([]byte, error) {
+	return readPage(kv.mmap.chunks, ptr)
+}
+
+func readPage(chunks [][]byte, ptr uint64) ([]byte, error) {
 	start := uint64(0)
 
-	for _, chunk := range kv.mmap.chunks {
+	for _, chunk := range chunks {
 		end := uint64(len(chunk)/btree.BNODE_PAGE_SIZE) + start
 		if ptr < end {
 			offset := (ptr - start) * btree.BNODE_PAGE_SIZE
-			return chunk[offset:][:btree.BNODE_PAGE_SIZE]
+			page := chunk[offset:][:btree.BNODE_PAGE_SIZE]
+			if !validChecksum(page) {
+				return nil, &CorruptPageError{Page: ptr, Reason: "checksum mismatch"}
+			}
+			return page, nil
 		}
 		start = end
 	}
-	panic("bad ptr")
+	return nil, &CorruptPageError{Page: ptr, Reason: "beyond the end of the file"}
 }
 
-func (kv *KV) appendPage(node []byte) uint64 {
+func (kv *KV) appendPage(node []byte) (uint64, error) {
 	ptr := kv.pages.flushed + kv.pages.nappend
 	kv.pages.nappend++
 	kv.pages.updated[ptr] = node
-	return ptr
+	return ptr, nil
 }
 
 func (kv *KV) updateFile() error {
@@ -254,24 +509,46 @@ func (kv *KV) updateFile() error {
 		return err
 	}
 	// 2. flush file to make sure nodes are written
-	if err := syscall.Fsync(kv.fd); err != nil {
+	if err := kv.sync(); err != nil {
 		return err
 	}
 
-	// 3. update root
+	// 3. update root, reverting restores the version from the old meta
+	kv.version++
 	if err := kv.updateRoot(); err != nil {
 		return err
 	}
 
-	if err := syscall.Fsync(kv.fd); err != nil {
+	if err := kv.sync(); err != nil {
 		return err
 	}
-	kv.freelist.SetMaxIdx()
+	kv.metaSlot = int(kv.version % META_SLOTS)
+	kv.publish()
 	return nil
 }
 
+func (kv *KV) sync() error {
+	if kv.opts.NoSync {
+		return nil
+	}
+	return syscall.Fsync(kv.fd)
+}
+
+// publish makes the current root visible to new transactions
+func (kv *KV) publish() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.snapshot.root = kv.tree.Root
+	kv.snapshot.tailIdx = kv.freelist.TailIdx
+	kv.snapshot.version = kv.version
+	kv.freelist.SetMaxIdx()
+}
+
+// updateRoot writes the meta to the slot the previous commit didn't use
 func (kv *KV) updateRoot() error {
-	if _, err := unix.Pwrite(kv.fd, kv.createMeta(), 0); err != nil {
+	offset := int64(kv.version%META_SLOTS) * META_SLOT_OFFSET
+	if _, err := unix.Pwrite(kv.fd, kv.createMeta(), offset); err != nil {
 		return err
 	}
 	return nil
@@ -280,31 +557,119 @@ func (kv *KV) updateRoot() error {
 func (kv *KV) updateOrRevert(meta []byte) error {
 	err := kv.updateFile()
 	if err != nil {
-		// revert
-		kv.loadMeta(meta)
-		kv.pages.nappend = 0
-		clear(kv.pages.updated)
+		kv.revert(meta)
 	}
 	return err
 }
 
+// revert drops every pending page and goes back to the state in meta
+func (kv *KV) revert(meta []byte) {
+	kv.loadMeta(meta)
+	kv.pages.nappend = 0
+	clear(kv.pages.updated)
+}
+
 func (kv *KV) readRoot(filesize int) error {
+	if filesize == 0 && kv.opts.ReadOnly {
+		return fmt.Errorf("%s: %w: empty file", kv.filename, ErrBadSignature)
+	}
 	if filesize == 0 {
 		kv.pages.flushed = 2 // meta + freelist dummy page
 		kv.freelist.HeadPage = 1
 		kv.freelist.TailPage = 1
 		kv.freelist.HeadIdx = 0
 		kv.freelist.TailIdx = 0
-		return nil
+		// write the empty freelist page and the meta right away so the
+		// freelist page is always readable, even if the first tx aborts
+		kv.pages.updated[1] = make([]byte, btree.BNODE_PAGE_SIZE)
+		return kv.updateFile()
+	}
+	if filesize < btree.BNODE_PAGE_SIZE {
+		return fmt.Errorf("%s: %w: file of %d bytes has no meta page", kv.filename, ErrBadSignature, filesize)
 	}
 
-	// if file alreacy exists need to load meta page in mmap
-	chunk, err := syscall.Mmap(kv.fd, 0, filesize, syscall.PROT_READ, syscall.MAP_SHARED)
+	// if file alreacy exists need to load meta page in mmap. Only whole
+	// pages are mapped, a torn append leaves a tail past the last one that
+	// the next pages written go over, and later chunks stay page aligned.
+	mapped := filesize / btree.BNODE_PAGE_SIZE * btree.BNODE_PAGE_SIZE
+	chunk, err := syscall.Mmap(kv.fd, 0, mapped, syscall.PROT_READ, syscall.MAP_SHARED)
 	if err != nil {
 		return fmt.Errorf("mmap: %w", err)
 	}
 	kv.mmap.chunks = append(kv.mmap.chunks, chunk)
-	kv.mmap.size += uint64(filesize)
-	kv.loadMeta(kv.mmap.chunks[0])
+	kv.mmap.size += uint64(mapped)
+
+	slot, _, err := newestMeta(chunk)
+	if err != nil {
+		return fmt.Errorf("%s: %w", kv.filename, err)
+	}
+	kv.metaSlot = slot
+	kv.loadMeta(chunk[slot*META_SLOT_OFFSET:][:META_SIZE])
+	return nil
+}
+
+// newestMeta picks the newest slot of the meta page that survived
+func newestMeta(page []byte) (int, uint64, error) {
+	if len(page) < btree.BNODE_PAGE_SIZE {
+		return 0, 0, fmt.Errorf("%w: meta page of %d bytes", ErrBadSignature, len(page))
+	}
+	found := false
+	var newest uint64
+	var newestSlot int
+	var slotErr error
+	for i := range META_SLOTS {
+		slot := page[i*META_SLOT_OFFSET:][:META_SIZE]
+		version, err := validMeta(slot)
+		if err != nil {
+			// a torn slot says more about the file than an unused one
+			if slotErr == nil || !errors.Is(err, ErrBadSignature) {
+				slotErr = err
+			}
+			continue
+		}
+		if !found || version > newest {
+			found, newest, newestSlot = true, version, i
+		}
+	}
+	if !found {
+		return 0, 0, slotErr
+	}
+	return newestSlot, newest, nil
+}
+
+// Refresh moves a read-only store to the last commit made by a writer in
+// another process, see Options.NoLock. Transactions that are already open
+// keep their snapshot. A store that writes always sees its own commits, so
+// it has nothing to do.
+func (kv *KV) Refresh() error {
+	if !kv.opts.ReadOnly {
+		return nil
+	}
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	kv.mu.Lock()
+	closed := kv.closed
+	kv.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	// the writer updates a slot after its pages, one torn by a write in
+	// progress just leaves us on the commit before
+	page := bytes.Clone(kv.mmap.chunks[0][:btree.BNODE_PAGE_SIZE])
+	slot, version, err := newestMeta(page)
+	if err != nil {
+		return fmt.Errorf("%s: %w", kv.filename, err)
+	}
+	if version <= kv.version {
+		return nil
+	}
+	kv.metaSlot = slot
+	kv.loadMeta(page[slot*META_SLOT_OFFSET:][:META_SIZE])
+	if err := kv.extendMMap(kv.pages.flushed * btree.BNODE_PAGE_SIZE); err != nil {
+		return err
+	}
+	kv.publish()
 	return nil
 }
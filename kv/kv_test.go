@@ -2,21 +2,24 @@ package kv
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func deleteDB(pathname string) error {
 	return os.Remove(pathname)
 }
 func TestInsert(t *testing.T) {
-	dbName := fmt.Sprintf("../db/test_%d.db", rand.Intn(1))
+	dbName := filepath.Join(t.TempDir(), fmt.Sprintf("test_%d.db", rand.Intn(1)))
 	defer deleteDB(dbName)
-	kv := KV{}
-	if err := kv.Init(dbName); err != nil {
+	kv, err := Open(dbName, nil)
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -51,9 +54,26 @@ func TestInsert(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	dbName := fmt.Sprintf("../db/test_%d.db", rand.Intn(1))
-	kv := KV{}
-	if err := kv.Init(dbName); err != nil {
+	dbName := filepath.Join(t.TempDir(), fmt.Sprintf("test_%d.db", rand.Intn(1)))
+	writer, err := Open(dbName, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range 10 {
+		k := fmt.Appendf(nil, "key_%d", 10+i)
+		v := fmt.Appendf(nil, "val_%d", 10+i)
+		if err := writer.Insert(k, v); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// reopen and read back what was persisted
+	kv, err := Open(dbName, nil)
+	if err != nil {
 		log.Fatal(err)
 	}
 	for i := range 10 {
@@ -70,9 +90,9 @@ func TestGet(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	kv := KV{}
-
-	if err := kv.Init("../db/test_delete.db"); err != nil {
+	dbName := filepath.Join(t.TempDir(), "test_delete.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -80,7 +100,7 @@ func TestDelete(t *testing.T) {
 		k := fmt.Appendf(nil, "k_%d", i)
 		v := fmt.Appendf(nil, "v_%d", i)
 		if err := kv.Insert(k, v); err != nil {
-			deleteDB("../db/test_delete.db")
+			deleteDB(dbName)
 			log.Fatal(err)
 		}
 	}
@@ -88,7 +108,7 @@ func TestDelete(t *testing.T) {
 	for i := range 500 {
 		k := fmt.Appendf(nil, "k_%d", i)
 		if err := kv.Delete(k); err != nil {
-			deleteDB("../db/test_delete.db")
+			deleteDB(dbName)
 
 			log.Fatal(err)
 		}
@@ -98,10 +118,232 @@ func TestDelete(t *testing.T) {
 		k := fmt.Appendf(nil, "k_%d", 500)
 		v, _ := kv.Get(k)
 		if len(v) == 0 {
-			deleteDB("../db/test_delete.db")
+			deleteDB(dbName)
 			log.Fatalf("%d was found\n", i)
 		}
 	}
 
+}
+
+func TestErrors(t *testing.T) {
+	dir := t.TempDir()
+	kv, err := Open(filepath.Join(dir, "errors.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if err := kv.Insert(make([]byte, 2000), nil); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge got %v", err)
+	}
+	if err := kv.Insert([]byte("k"), make([]byte, 2000)); err != nil {
+		t.Fatalf("values bigger than a leaf go to overflow pages: %v", err)
+	}
+	if _, _, err := kv.GetVersion(nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty key: expected ErrNotFound got %v", err)
+	}
+	if _, err := kv.OpenReader([]byte{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty key: expected ErrNotFound got %v", err)
+	}
+
+	notADB := filepath.Join(dir, "not_a_db")
+	if err := os.WriteFile(notADB, bytes.Repeat([]byte("x"), 3*4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(notADB, nil); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature got %v", err)
+	}
+	// too short to hold the meta page
+	for _, size := range []int{5, 2100, 4095} {
+		if err := os.WriteFile(notADB, bytes.Repeat([]byte("x"), size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(notADB, nil); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%d bytes: expected ErrBadSignature got %v", size, err)
+		}
+	}
+}
+
+// TestEmptyKey makes sure the empty key, which every tree keeps as its
+// first entry, can't be written or deleted.
+func TestEmptyKey(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "empty.db"), &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if err := kv.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Delete(nil); !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("delete: expected ErrEmptyKey got %v", err)
+	}
+	if err := kv.Insert([]byte{}, []byte("v")); !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("insert: expected ErrEmptyKey got %v", err)
+	}
+	if ok, err := kv.SetIfVersion(nil, []byte("v"), 0); ok || !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("set if version: expected ErrEmptyKey got %v %v", ok, err)
+	}
+	if err := kv.PutReader(nil, bytes.NewReader([]byte("v")), 1); !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("put reader: expected ErrEmptyKey got %v", err)
+	}
+	tx, err := kv.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setErr, delErr := tx.Set(nil, []byte("v")), tx.Delete(nil)
+	tx.Abort()
+	if !errors.Is(setErr, ErrEmptyKey) || !errors.Is(delErr, ErrEmptyKey) {
+		t.Fatalf("tx: expected ErrEmptyKey got %v and %v", setErr, delErr)
+	}
+
+	// the tree is still in order
+	if err := kv.Insert([]byte("0"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := kv.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.Keys != 2 {
+		t.Fatalf("check: %d keys, problems %v", r.Keys, r.Problems)
+	}
+}
 
+// TestMmapSize makes sure a mapping size that isn't a whole number of
+// pages doesn't leave the next mapping unaligned.
+func TestMmapSize(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "mmap.db"), &Options{InitialMmapSize: 10000, NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	for i := range 2000 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%04d", i), make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := kv.Get([]byte("k_1999")); err != nil || len(v) != 100 {
+		t.Fatalf("k_1999: %d bytes %v", len(v), err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "close.db")
+	kv, err := Open(dbName, &Options{InitialMmapSize: 1 << 20, NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 500 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Close waits for the open iterator
+	it := kv.Scan(nil, nil)
+	closed := make(chan error)
+	go func() { closed <- kv.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned with an open iterator: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !it.Valid() {
+		t.Fatal("iterator should still be readable")
+	}
+	it.Close()
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kv.Get([]byte("k_1")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed got %v", err)
+	}
+	if err := kv.Insert([]byte("k"), nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed got %v", err)
+	}
+	if it := kv.Scan(nil, nil); it.Valid() || !errors.Is(it.Err(), ErrClosed) {
+		t.Fatalf("expected ErrClosed got %v", it.Err())
+	}
+	if err := kv.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed got %v", err)
+	}
+
+	ro, err := Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if v, err := ro.Get([]byte("k_499")); err != nil || string(v) != "v_499" {
+		t.Fatalf("k_499: got %s %v", v, err)
+	}
+	if err := ro.Insert([]byte("k"), nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "update.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &UpdateReq{Key: []byte("k"), Val: []byte("1"), Mode: MODE_INSERT_ONLY}
+	if err := kv.Update(req); err != nil || !req.Updated || req.Existed {
+		t.Fatalf("insert-only: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Val: []byte("2"), Mode: MODE_INSERT_ONLY}
+	if err := kv.Update(req); err != nil || req.Updated || string(req.Old) != "1" {
+		t.Fatalf("insert-only of an existing key: %+v %v", req, err)
+	}
+	req = &UpdateReq{Key: []byte("missing"), Val: []byte("2"), Mode: MODE_UPDATE_ONLY}
+	if err := kv.Update(req); err != nil || req.Updated {
+		t.Fatalf("update-only of a missing key: %+v %v", req, err)
+	}
+
+	// a transaction that read k conflicts with the single write
+	tx := mustBegin(t, kv)
+	if _, err := tx.Get([]byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	req = &UpdateReq{Key: []byte("k"), Val: []byte("3"), Mode: MODE_UPDATE_ONLY}
+	if err := kv.Update(req); err != nil || !req.Updated || string(req.Old) != "1" {
+		t.Fatalf("update-only: %+v %v", req, err)
+	}
+	if err := tx.Set([]byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict got %v", err)
+	}
+
+	req = &UpdateReq{Key: []byte("k"), Mode: MODE_DELETE}
+	if err := kv.Update(req); err != nil || !req.Existed || string(req.Old) != "3" {
+		t.Fatalf("delete: %+v %v", req, err)
+	}
+	if err := kv.Delete([]byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if err := kv.Insert([]byte("kept"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Get([]byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the delete wasn't persisted: %v", err)
+	}
+	if v, err := reopened.Get([]byte("kept")); err != nil || string(v) != "v" {
+		t.Fatalf("kept: got %s %v", v, err)
+	}
 }
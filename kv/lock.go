@@ -0,0 +1,56 @@
+package kv
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// how often a blocked open retries the lock
+const LOCK_RETRY_INTERVAL = 10 * time.Millisecond
+
+// lock takes an advisory flock on the file, exclusive for a read-write open
+// and shared for a read-only one, so a writer never shares the file with
+// another process. It retries until LockTimeout and returns ErrLocked if the
+// lock is still held by someone else. The lock goes away with the fd.
+func (kv *KV) lock() error {
+	how := syscall.LOCK_EX
+	if kv.opts.ReadOnly {
+		how = syscall.LOCK_SH
+	}
+
+	deadline := time.Now().Add(kv.opts.LockTimeout)
+	for {
+		err := syscall.Flock(kv.fd, how|syscall.LOCK_NB)
+		switch {
+		case err == nil:
+			return nil
+		case err == syscall.EINTR:
+			continue
+		case err != syscall.EWOULDBLOCK:
+			return fmt.Errorf("flock: %w", err)
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %s", ErrLocked, kv.filename)
+		}
+		time.Sleep(min(LOCK_RETRY_INTERVAL, time.Until(deadline)))
+	}
+}
+
+// replaced reports whether the file kv has open is no longer the one at
+// its path, because it was renamed over or removed.
+func (kv *KV) replaced() (bool, error) {
+	var open, named syscall.Stat_t
+	if err := syscall.Fstat(kv.fd, &open); err != nil {
+		return false, fmt.Errorf("fstat: %w", err)
+	}
+	err := syscall.Stat(kv.filename, &named)
+	if err == syscall.ENOENT {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat: %w", err)
+	}
+	return open.Dev != named.Dev || open.Ino != named.Ino, nil
+}
@@ -0,0 +1,102 @@
+package kv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "lock.db")
+	writer, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dbName, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked got %v", err)
+	}
+	if _, err := Open(dbName, &Options{ReadOnly: true}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked for a reader got %v", err)
+	}
+
+	// a waiting open gets the file once the writer is gone
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writer.Close()
+	}()
+	start := time.Now()
+	writer, err = Open(dbName, &Options{LockTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("open should have waited for the lock")
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers share the file
+	r1, err := Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	if _, err := Open(dbName, &Options{LockTimeout: 20 * time.Millisecond}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked got %v", err)
+	}
+}
+
+// TestLockReplaced has the file renamed over while an open waits for its
+// lock, the way Compact does, the open must end up with the new file.
+func TestLockReplaced(t *testing.T) {
+	dir := t.TempDir()
+	dbName := filepath.Join(dir, "lock.db")
+	holder, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Open(filepath.Join(dir, "other.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Insert([]byte("k"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan *KV)
+	go func() {
+		kv, err := Open(dbName, &Options{LockTimeout: 5 * time.Second})
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- kv
+	}()
+	time.Sleep(50 * time.Millisecond) // the open is waiting for the lock
+	if err := os.Rename(filepath.Join(dir, "other.db"), dbName); err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv := <-opened
+	if kv == nil {
+		t.FailNow()
+	}
+	defer kv.Close()
+	if v, err := kv.Get([]byte("k")); err != nil || string(v) != "new" {
+		t.Fatalf("expected the renamed file, got %q %v", v, err)
+	}
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// MergeFunc combines the current value of a key with an operand into its
+// new value. exists is false, and old nil, for a missing key.
+type MergeFunc func(exists bool, old, operand []byte) ([]byte, error)
+
+var mergeOps = struct {
+	sync.RWMutex
+	funcs map[string]MergeFunc
+}{
+	funcs: map[string]MergeFunc{
+		"add":    mergeAdd,
+		"max":    mergeMax,
+		"append": mergeAppend,
+	},
+}
+
+// RegisterMerge makes fn available to Merge under name. The built in
+// operators are "add" and "max", over int64 values stored as 8 bytes
+// little endian, and "append".
+func RegisterMerge(name string, fn MergeFunc) error {
+	if fn == nil {
+		return fmt.Errorf("merge operator %q: nil func", name)
+	}
+	mergeOps.Lock()
+	defer mergeOps.Unlock()
+	if _, ok := mergeOps.funcs[name]; ok {
+		return fmt.Errorf("merge operator %q is already registered", name)
+	}
+	mergeOps.funcs[name] = fn
+	return nil
+}
+
+// Merge sets k to the result of the operator op applied to its current
+// value and operand. The value is read and written in the same pass down
+// the tree and the same commit, concurrent merges never lose an update.
+func (kv *KV) Merge(k, operand []byte, op string) error {
+	mergeOps.RLock()
+	fn, ok := mergeOps.funcs[op]
+	mergeOps.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownMerge, op)
+	}
+
+	return kv.Update(&UpdateReq{
+		Key:  k,
+		Mode: MODE_UPSERT,
+		Merge: func(exists bool, old []byte) ([]byte, error) {
+			return fn(exists, old, operand)
+		},
+	})
+}
+
+func int64Operands(exists bool, old, operand []byte) (int64, int64, error) {
+	if len(operand) != 8 || (exists && len(old) != 8) {
+		return 0, 0, fmt.Errorf("%w: int64 values are 8 bytes", ErrMergeOperand)
+	}
+	var a int64
+	if exists {
+		a = int64(binary.LittleEndian.Uint64(old))
+	}
+	return a, int64(binary.LittleEndian.Uint64(operand)), nil
+}
+
+func mergeAdd(exists bool, old, operand []byte) ([]byte, error) {
+	a, b, err := int64Operands(exists, old, operand)
+	if err != nil {
+		return nil, err
+	}
+	return binary.LittleEndian.AppendUint64(nil, uint64(a+b)), nil
+}
+
+func mergeMax(exists bool, old, operand []byte) ([]byte, error) {
+	a, b, err := int64Operands(exists, old, operand)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		b = max(a, b)
+	}
+	return binary.LittleEndian.AppendUint64(nil, uint64(b)), nil
+}
+
+func mergeAppend(_ bool, old, operand []byte) ([]byte, error) {
+	return append(append(make([]byte, 0, len(old)+len(operand)), old...), operand...), nil
+}
@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func int64Bytes(n int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(n))
+}
+
+func TestMerge(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "merge.db"), &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	workers, increments := 8, 50
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				if err := kv.Merge([]byte("hits"), int64Bytes(2), "add"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, err := kv.Get([]byte("hits")); err != nil || !bytes.Equal(v, int64Bytes(int64(2*workers*increments))) {
+		t.Fatalf("hits: got %v %v", v, err)
+	}
+
+	for _, n := range []int64{5, -3, 9, 7} {
+		if err := kv.Merge([]byte("peak"), int64Bytes(n), "max"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _ := kv.Get([]byte("peak")); !bytes.Equal(v, int64Bytes(9)) {
+		t.Fatalf("peak: got %v", v)
+	}
+
+	for _, s := range []string{"a", "b", "c"} {
+		if err := kv.Merge([]byte("log"), []byte(s), "append"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _ := kv.Get([]byte("log")); string(v) != "abc" {
+		t.Fatalf("log: got %s", v)
+	}
+
+	if err := kv.Merge([]byte("log"), []byte("x"), "add"); !errors.Is(err, ErrMergeOperand) {
+		t.Fatalf("expected ErrMergeOperand got %v", err)
+	}
+	if v, _ := kv.Get([]byte("log")); string(v) != "abc" {
+		t.Fatalf("a failed merge changed the value: %s", v)
+	}
+	if err := kv.Merge([]byte("log"), nil, "nope"); !errors.Is(err, ErrUnknownMerge) {
+		t.Fatalf("expected ErrUnknownMerge got %v", err)
+	}
+}
+
+func TestRegisterMerge(t *testing.T) {
+	upper := func(_ bool, _, operand []byte) ([]byte, error) {
+		return bytes.ToUpper(operand), nil
+	}
+	if err := RegisterMerge("test_upper", upper); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMerge("test_upper", upper); err == nil {
+		t.Fatal("registered the same name twice")
+	}
+
+	kv, err := Open(filepath.Join(t.TempDir(), "register.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if err := kv.Merge([]byte("k"), []byte("shout"), "test_upper"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := kv.Get([]byte("k")); string(v) != "SHOUT" {
+		t.Fatalf("k: got %s", v)
+	}
+}
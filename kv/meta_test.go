@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetaSlots(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "meta.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 commit to create the file + 5 inserts
+	if kv.MetaSlot() != 0 {
+		t.Fatalf("expected slot 0 got %d", kv.MetaSlot())
+	}
+
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.MetaSlot() != 0 || reopened.version != 6 {
+		t.Fatalf("expected slot 0 version 6, got slot %d version %d", reopened.MetaSlot(), reopened.version)
+	}
+}
+
+func TestTornMeta(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "torn.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the insert of b was the 3rd commit and went to slot 1, tear it
+	f, err := os.OpenFile(dbName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("garbage"), META_SLOT_OFFSET+20); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.MetaSlot() != 0 {
+		t.Fatalf("expected fallback to slot 0, got %d", reopened.MetaSlot())
+	}
+	if v, err := reopened.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("a: got %s %v", v, err)
+	}
+	if _, err := reopened.Get([]byte("b")); err == nil {
+		t.Fatal("b was only in the torn meta")
+	}
+
+	// the next commit overwrites the torn slot
+	if err := reopened.Insert([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.MetaSlot() != 1 {
+		t.Fatalf("expected slot 1 got %d", reopened.MetaSlot())
+	}
+
+	// with both slots gone the file can't be opened
+	if _, err := f.WriteAt([]byte("garbage"), 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("garbage"), META_SLOT_OFFSET+20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dbName, nil); err == nil {
+		t.Fatal("expected an error for a file without valid meta")
+	}
+}
+
+// TestTornAppend opens a file that ends part way into a page, the way an
+// append cut by a crash leaves it.
+func TestTornAppend(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "append.db")
+	kv, err := Open(dbName, &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(dbName, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	kv, err = Open(dbName, &Options{NoSync: true, InitialMmapSize: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enough to map more of the file
+	for i := range 2000 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%04d", i), make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	kv, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if v, err := kv.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("a: got %s %v", v, err)
+	}
+	if r, err := kv.Check(); err != nil || !r.OK() || r.Keys != 2001 {
+		t.Fatalf("check: %+v %v", r, err)
+	}
+}
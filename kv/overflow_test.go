@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverflow(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "overflow.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := func(i int) []byte {
+		return bytes.Repeat(fmt.Appendf(nil, "blob %d ", i), 3000)
+	}
+	for i := range 10 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), blob(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	it := kv.Scan(nil, nil)
+	i := 0
+	for ; it.Valid(); it.Next() {
+		if !bytes.Equal(it.Value(), blob(i)) {
+			t.Fatalf("%s: wrong value of %d bytes", it.Key(), len(it.Value()))
+		}
+		i++
+	}
+	it.Close()
+	if it.Err() != nil || i != 10 {
+		t.Fatalf("scanned %d keys: %v", i, it.Err())
+	}
+
+	// overwriting the blobs reuses the pages of the old ones
+	stat, err := os.Stat(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		for i := range 10 {
+			if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), blob(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	grown, err := os.Stat(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grown.Size() > 3*stat.Size() {
+		t.Fatalf("file grew from %d to %d bytes, old pages aren't reused", stat.Size(), grown.Size())
+	}
+
+	for i := range 10 {
+		if err := kv.Delete(fmt.Appendf(nil, "k_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := kv.Get([]byte("k_0")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "ro.db")
+	if _, err := Open(dbName, &Options{ReadOnly: true}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing file error got %v", err)
+	}
+	if _, err := os.Stat(dbName); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("a read-only open created the file")
+	}
+
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := Open(dbName, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ro.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("a: got %s %v", v, err)
+	}
+	if err := ro.Insert([]byte("b"), nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly got %v", err)
+	}
+	if err := ro.Delete([]byte("a")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly got %v", err)
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("a read-only open changed the file")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "refresh.db")
+	writer, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err := writer.Insert([]byte("k_0"), []byte("v_0")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := Open(dbName, &Options{ReadOnly: true, NoLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// enough commits to grow the file past the reader's mapping
+	for i := 1; i < 2000; i++ {
+		if err := writer.Insert(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Get([]byte("k_1")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("reader should still be on its first commit, got %v", err)
+	}
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2000 {
+		v, err := reader.Get(fmt.Appendf(nil, "k_%d", i))
+		if err != nil || string(v) != fmt.Sprintf("v_%d", i) {
+			t.Fatalf("k_%d: got %s %v", i, v, err)
+		}
+	}
+}
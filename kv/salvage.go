@@ -0,0 +1,170 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// SalvageStats reports what Salvage found.
+type SalvageStats struct {
+	Pages   uint64 // in the damaged file
+	Corrupt int    // pages that failed their checksum
+	Free    int    // pages skipped because the freelist had them
+	Leaves  int    // pages that passed for leaves
+	Keys    int    // written to the new database
+	Lost    int    // keys whose overflow pages couldn't be read
+}
+
+// salvaged is the newest copy of a key found so far.
+type salvaged struct {
+	val      []byte
+	version  uint64
+	overflow bool
+}
+
+// Salvage is the last resort for a database that can't be opened or read
+// through its tree anymore. It reads every page of src on its own, takes
+// the ones that pass for leaves and writes the newest copy, by version, of
+// every key they hold to a new database at dst. Without a meta to go by,
+// keys deleted from src can come back from pages that were never reused.
+func Salvage(src, dst string) (*SalvageStats, error) {
+	if _, err := os.Stat(dst); err == nil {
+		return nil, fmt.Errorf("salvage: %s: %w", dst, os.ErrExist)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &SalvageStats{Pages: uint64(fi.Size()) / btree.BNODE_PAGE_SIZE}
+	raw := func(ptr uint64) ([]byte, error) {
+		if ptr >= stats.Pages {
+			return nil, &CorruptPageError{Page: ptr, Reason: "beyond the end of the file"}
+		}
+		page := make([]byte, btree.BNODE_PAGE_SIZE)
+		if _, err := f.ReadAt(page, int64(ptr)*btree.BNODE_PAGE_SIZE); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	read := func(ptr uint64) ([]byte, error) {
+		page, err := raw(ptr)
+		if err != nil {
+			return nil, err
+		}
+		if !validChecksum(page) {
+			return nil, &CorruptPageError{Page: ptr, Reason: "checksum mismatch"}
+		}
+		return page, nil
+	}
+
+	free := salvageFree(raw, read, stats.Pages)
+	found := make(map[string]*salvaged)
+	for ptr := uint64(1); ptr < stats.Pages; ptr++ {
+		if free[ptr] {
+			stats.Free++
+			continue
+		}
+		page, err := read(ptr)
+		if err != nil {
+			stats.Corrupt++
+			continue
+		}
+		pairs, err := btree.ReadLeaf(page)
+		if err != nil {
+			continue
+		}
+		stats.Leaves++
+		for _, p := range pairs {
+			if len(p.Key) == 0 {
+				continue // the sentinel
+			}
+			if s := found[string(p.Key)]; s != nil && s.version >= p.Version {
+				continue
+			}
+			found[string(p.Key)] = &salvaged{val: p.Val, version: p.Version, overflow: p.Overflow}
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	var version uint64
+	for k, s := range found {
+		keys = append(keys, k)
+		version = max(version, s.version)
+	}
+	slices.Sort(keys)
+
+	db, err := Open(dst, &Options{NoSync: true})
+	if err != nil {
+		return nil, err
+	}
+	err = db.fill(version, func(b *btree.Builder) error {
+		for _, k := range keys {
+			s := found[k]
+			v := s.val
+			if s.overflow {
+				// a chain can't be longer than the file
+				reads := uint64(0)
+				var err error
+				v, err = btree.ReadOverflow(func(ptr uint64) ([]byte, error) {
+					if reads++; reads > stats.Pages {
+						return nil, &CorruptPageError{Page: ptr, Reason: "overflow chain loops"}
+					}
+					return read(ptr)
+				}, s.val)
+				if err != nil {
+					stats.Lost++
+					continue
+				}
+			}
+			if err := b.Add([]byte(k), v, s.version); err != nil {
+				return err
+			}
+			stats.Keys++
+		}
+		return nil
+	})
+	if err := errors.Join(err, db.Close()); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// salvageFree returns the pages in the freelist of the newest meta that
+// survived, if it can still be read. What they hold was deleted or
+// overwritten since.
+func salvageFree(raw, read func(uint64) ([]byte, error), pages uint64) map[uint64]bool {
+	page, err := raw(0)
+	if err != nil {
+		return nil
+	}
+	slot, _, err := newestMeta(page)
+	if err != nil {
+		return nil
+	}
+	var meta KV
+	meta.loadMeta(page[slot*META_SLOT_OFFSET:][:META_SIZE])
+	fl := &meta.freelist
+	if meta.pages.flushed > pages || fl.TailIdx-fl.HeadIdx > pages {
+		return nil
+	}
+	fl.Get = read
+	free := make(map[uint64]bool)
+	_, err = fl.Walk(func(_, ptr uint64) error {
+		free[ptr] = true
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+	return free
+}
@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// newSalvageDB writes every key a few times so older copies are left in
+// free pages, deletes some and returns what the keys hold last.
+func newSalvageDB(t *testing.T, dbName string) (map[string][]byte, uint64) {
+	t.Helper()
+	kv, err := Open(dbName, &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string][]byte)
+	for gen := range 3 {
+		for i := range 500 {
+			k := fmt.Sprintf("k_%04d", i)
+			v := fmt.Appendf(nil, "gen_%d_%d", gen, i)
+			if i%50 == 0 {
+				v = bytes.Repeat(v, 1000)
+			}
+			if err := kv.Insert([]byte(k), v); err != nil {
+				t.Fatal(err)
+			}
+			expected[k] = v
+		}
+	}
+	for i := range 100 {
+		k := fmt.Sprintf("k_%04d", i)
+		if err := kv.Delete([]byte(k)); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, k)
+	}
+	root := kv.tree.Root
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return expected, root
+}
+
+func damagePage(t *testing.T, dbName string, ptr uint64) {
+	t.Helper()
+	f, err := os.OpenFile(dbName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	garbage := bytes.Repeat([]byte{0xab}, btree.BNODE_PAGE_SIZE)
+	if _, err := f.WriteAt(garbage, int64(ptr)*btree.BNODE_PAGE_SIZE); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSalvaged(t *testing.T, dbName string, expected map[string][]byte, deleted bool) {
+	t.Helper()
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	for k, v := range expected {
+		got, err := kv.Get([]byte(k))
+		if err != nil || !bytes.Equal(got, v) {
+			t.Fatalf("%s: got %d bytes %v", k, len(got), err)
+		}
+	}
+	if keys := collect(kv.Scan(nil, nil)); deleted && len(keys) != len(expected) {
+		t.Fatalf("expected %d keys got %d", len(expected), len(keys))
+	}
+	if r := mustCheck(t, kv); !r.OK() {
+		t.Fatal(r.Problems)
+	}
+}
+
+func TestSalvageMeta(t *testing.T) {
+	dir := t.TempDir()
+	dbName := filepath.Join(dir, "damaged.db")
+	expected, _ := newSalvageDB(t, dbName)
+	damagePage(t, dbName, 0)
+	if _, err := Open(dbName, nil); err == nil {
+		t.Fatal("expected the damaged file not to open")
+	}
+
+	stats, err := Salvage(dbName, filepath.Join(dir, "salvaged.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys < len(expected) || stats.Lost != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// without the freelist deleted keys may be back, the others have
+	// their newest value
+	checkSalvaged(t, filepath.Join(dir, "salvaged.db"), expected, false)
+
+	if _, err := Salvage(dbName, filepath.Join(dir, "salvaged.db")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected ErrExist got %v", err)
+	}
+}
+
+func TestSalvageRoot(t *testing.T) {
+	dir := t.TempDir()
+	dbName := filepath.Join(dir, "damaged.db")
+	expected, root := newSalvageDB(t, dbName)
+	damagePage(t, dbName, root)
+
+	stats, err := Salvage(dbName, filepath.Join(dir, "salvaged.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != len(expected) || stats.Free == 0 || stats.Corrupt == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	checkSalvaged(t, filepath.Join(dir, "salvaged.db"), expected, true)
+}
@@ -0,0 +1,235 @@
+package kv
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// Iterator walks the keys of a KV in the half-open range [start, end).
+// A nil start or end leaves that side of the range unbounded. Inside a
+// read-write Tx it merges the transaction's pending writes over the tree.
+type Iterator struct {
+	iter    *btree.BIter
+	pending *pendingIter // nil outside a read-write Tx
+	start   []byte
+	end     []byte
+	reverse bool
+
+	// set when the iterator owns its read transaction
+	tx  *ReadTx
+	err error // the read transaction couldn't start
+
+	// current entry, picked by settle
+	key         []byte
+	val         []byte
+	valid       bool
+	fromPending bool
+	shadowed    bool // the pending entry hides the tree entry with the same key
+}
+
+func newIterator(tree *btree.Btree, pending *pendingIter, start, end []byte, reverse bool) *Iterator {
+	it := &Iterator{
+		pending: pending,
+		start:   start,
+		end:     end,
+		reverse: reverse,
+	}
+	switch {
+	case !reverse:
+		it.iter = tree.SeekGE(start)
+	case end == nil:
+		it.iter = tree.SeekLast()
+	default:
+		it.iter = tree.SeekLE(end)
+		if it.iter.Valid() && bytes.Equal(it.iter.Key(), end) {
+			it.iter.Prev() // end is exclusive
+		}
+	}
+	it.settle()
+	return it
+}
+
+// Scan returns an iterator over [start, end) in ascending key order. It
+// reads from a snapshot that is held until Close.
+func (kv *KV) Scan(start, end []byte) *Iterator {
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return &Iterator{err: err}
+	}
+	it := tx.Scan(start, end)
+	it.tx = tx
+	return it
+}
+
+// ScanReverse returns an iterator over [start, end) in descending key order.
+func (kv *KV) ScanReverse(start, end []byte) *Iterator {
+	tx, err := kv.BeginRead()
+	if err != nil {
+		return &Iterator{err: err}
+	}
+	it := tx.ScanReverse(start, end)
+	it.tx = tx
+	return it
+}
+
+// ScanPrefix returns an iterator over every key starting with prefix.
+func (kv *KV) ScanPrefix(prefix []byte) *Iterator {
+	return kv.Scan(prefix, PrefixEnd(prefix))
+}
+
+func (kv *KV) ScanPrefixReverse(prefix []byte) *Iterator {
+	return kv.ScanReverse(prefix, PrefixEnd(prefix))
+}
+
+// PrefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none (the prefix is all 0xff).
+func PrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (it *Iterator) inRange(key []byte) bool {
+	if it.reverse {
+		return it.start == nil || bytes.Compare(key, it.start) >= 0
+	}
+	return it.end == nil || bytes.Compare(key, it.end) < 0
+}
+
+func (it *Iterator) step() {
+	if it.reverse {
+		it.iter.Prev()
+	} else {
+		it.iter.Next()
+	}
+}
+
+// settle picks the current entry from the tree or the pending writes,
+// skipping keys the transaction deleted.
+func (it *Iterator) settle() {
+	for {
+		if it.iter.Err() != nil {
+			it.valid = false
+			return
+		}
+		inTree := it.iter.Valid() && it.inRange(it.iter.Key())
+		if it.pending == nil || !it.pending.valid() {
+			it.valid, it.fromPending = inTree, false
+			if inTree {
+				it.key, it.val = it.iter.Key(), it.iter.Value()
+				it.valid = it.iter.Err() == nil // an overflow page failed
+			}
+			return
+		}
+
+		key, w := it.pending.entry()
+		cmp := 1 // no tree entry, the pending one comes first
+		if inTree {
+			cmp = bytes.Compare(it.iter.Key(), key)
+			if it.reverse {
+				cmp = -cmp
+			}
+		}
+		if cmp < 0 {
+			it.key, it.val = it.iter.Key(), it.iter.Value()
+			it.valid, it.fromPending = it.iter.Err() == nil, false
+			return
+		}
+
+		it.shadowed = cmp == 0
+		if w.deleted {
+			it.pending.pos++
+			if it.shadowed {
+				it.step()
+			}
+			continue
+		}
+		it.key, it.val = key, w.val
+		it.valid, it.fromPending = true, true
+		return
+	}
+}
+
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+func (it *Iterator) Next() {
+	if !it.valid {
+		return
+	}
+	if !it.fromPending {
+		it.step()
+	} else {
+		it.pending.pos++
+		if it.shadowed {
+			it.step()
+		}
+	}
+	it.settle()
+}
+
+// Err returns the error that ended the iteration early, e.g. a corrupt
+// page, if any.
+func (it *Iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Err()
+}
+
+// Key returns the current key. The slice is only valid until Close.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the current value. The slice is only valid until Close.
+func (it *Iterator) Value() []byte {
+	return it.val
+}
+
+// Close releases the snapshot of an iterator returned by KV.Scan. It is a
+// no-op for iterators of a transaction, those are released when it ends.
+func (it *Iterator) Close() {
+	if it.tx != nil {
+		it.tx.End()
+	}
+}
+
+// pendingIter walks the writes of a Tx that fall in a range, in the order
+// of the scan.
+type pendingIter struct {
+	keys    []string
+	pending map[string]pendingWrite
+	pos     int
+}
+
+func newPendingIter(pending map[string]pendingWrite, start, end []byte, reverse bool) *pendingIter {
+	it := &pendingIter{pending: pending}
+	for k := range pending {
+		if k >= string(start) && (end == nil || k < string(end)) {
+			it.keys = append(it.keys, k)
+		}
+	}
+	slices.Sort(it.keys)
+	if reverse {
+		slices.Reverse(it.keys)
+	}
+	return it
+}
+
+func (it *pendingIter) valid() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *pendingIter) entry() ([]byte, pendingWrite) {
+	k := it.keys[it.pos]
+	return []byte(k), it.pending[k]
+}
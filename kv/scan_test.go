@@ -0,0 +1,82 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func newScanDB(t *testing.T) *KV {
+	kv, err := Open(filepath.Join(t.TempDir(), "scan.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "ab", "abc", "b", "ba", "c", "d"} {
+		if err := kv.Insert([]byte(k), []byte("v_"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kv
+}
+
+func collect(it *Iterator) []string {
+	defer it.Close()
+	keys := []string{}
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	kv := newScanDB(t)
+
+	tests := []struct {
+		name     string
+		it       *Iterator
+		expected []string
+	}{
+		{"all", kv.Scan(nil, nil), []string{"a", "ab", "abc", "b", "ba", "c", "d"}},
+		{"range", kv.Scan([]byte("ab"), []byte("c")), []string{"ab", "abc", "b", "ba"}},
+		{"open end", kv.Scan([]byte("bb"), nil), []string{"c", "d"}},
+		{"reverse all", kv.ScanReverse(nil, nil), []string{"d", "c", "ba", "b", "abc", "ab", "a"}},
+		{"reverse range", kv.ScanReverse([]byte("ab"), []byte("c")), []string{"ba", "b", "abc", "ab"}},
+		{"prefix", kv.ScanPrefix([]byte("ab")), []string{"ab", "abc"}},
+		{"reverse prefix", kv.ScanPrefixReverse([]byte("a")), []string{"abc", "ab", "a"}},
+		{"empty", kv.Scan([]byte("x"), nil), []string{}},
+	}
+
+	for _, tt := range tests {
+		got := collect(tt.it)
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Fatalf("%s: expected %v got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestScanValues(t *testing.T) {
+	kv := newScanDB(t)
+	it := kv.Scan(nil, nil)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		if string(it.Value()) != "v_"+string(it.Key()) {
+			t.Fatalf("wrong value %s for %s", it.Value(), it.Key())
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string][]byte{
+		"ab":         []byte("ac"),
+		"a\xff":      []byte("b"),
+		"\xff\xff":   nil,
+		"":           nil,
+		"a\xffb\xff": []byte("a\xffc"),
+	}
+	for prefix, expected := range tests {
+		got := PrefixEnd([]byte(prefix))
+		if string(got) != string(expected) || (got == nil) != (expected == nil) {
+			t.Fatalf("PrefixEnd(%q): expected %q got %q", prefix, expected, got)
+		}
+	}
+}
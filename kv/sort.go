@@ -0,0 +1,199 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// sortedPairs is a PairSource over the pairs of another one in key order.
+// Close removes the runs it spilled.
+type sortedPairs struct {
+	runs   []*run
+	merge  runHeap
+	memory []pair // set when everything fit in a single run
+	pos    int
+}
+
+type pair struct {
+	key []byte
+	val []byte
+}
+
+// sortPairs reads src whole, sorting it in runs of up to RunSize bytes
+// that are spilled to temp files and merged back on the fly by Next.
+func sortPairs(src PairSource, opts BulkOptions) (*sortedPairs, error) {
+	runSize := opts.RunSize
+	if runSize <= 0 {
+		runSize = DEFAULT_RUN_SIZE
+	}
+
+	s := &sortedPairs{}
+	var batch []pair
+	size := 0
+	for {
+		k, v, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("bulk load: %w", err)
+		}
+		batch = append(batch, pair{key: bytes.Clone(k), val: bytes.Clone(v)})
+		size += len(k) + len(v)
+		if size >= runSize {
+			if err := s.spill(batch, opts.TempDir); err != nil {
+				s.Close()
+				return nil, err
+			}
+			batch, size = batch[:0], 0
+		}
+	}
+
+	sortBatch(batch)
+	if len(s.runs) == 0 {
+		s.memory = batch
+		return s, nil
+	}
+	if len(batch) > 0 {
+		if err := s.spill(batch, opts.TempDir); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	for _, r := range s.runs {
+		if err := r.rewind(); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := r.next(); err != nil && err != io.EOF {
+			s.Close()
+			return nil, err
+		} else if err == nil {
+			s.merge = append(s.merge, r)
+		}
+	}
+	heap.Init(&s.merge)
+	return s, nil
+}
+
+func sortBatch(batch []pair) {
+	slices.SortStableFunc(batch, func(a, b pair) int {
+		return bytes.Compare(a.key, b.key)
+	})
+}
+
+// spill writes a sorted batch to a new run file
+func (s *sortedPairs) spill(batch []pair, dir string) error {
+	sortBatch(batch)
+	f, err := os.CreateTemp(dir, "mydb-sort-*.run")
+	if err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	r := &run{f: f}
+	s.runs = append(s.runs, r)
+
+	// KLEN | KEY | VLEN | VAL, lengths as uvarints
+	w := bufio.NewWriter(f)
+	for _, p := range batch {
+		w.Write(binary.AppendUvarint(nil, uint64(len(p.key))))
+		w.Write(p.key)
+		w.Write(binary.AppendUvarint(nil, uint64(len(p.val))))
+		w.Write(p.val)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	return nil
+}
+
+func (s *sortedPairs) Next() ([]byte, []byte, error) {
+	if s.runs == nil {
+		if s.pos == len(s.memory) {
+			return nil, nil, io.EOF
+		}
+		p := s.memory[s.pos]
+		s.pos++
+		return p.key, p.val, nil
+	}
+
+	if len(s.merge) == 0 {
+		return nil, nil, io.EOF
+	}
+	r := s.merge[0]
+	k, v := r.cur.key, r.cur.val
+	switch err := r.next(); {
+	case err == io.EOF:
+		heap.Pop(&s.merge)
+	case err != nil:
+		return nil, nil, err
+	default:
+		heap.Fix(&s.merge, 0)
+	}
+	return k, v, nil
+}
+
+func (s *sortedPairs) Close() error {
+	var errs []error
+	for _, r := range s.runs {
+		errs = append(errs, r.f.Close(), os.Remove(r.f.Name()))
+	}
+	return errors.Join(errs...)
+}
+
+// run reads back a spilled batch one pair at a time
+type run struct {
+	f   *os.File
+	r   *bufio.Reader
+	cur pair
+}
+
+func (r *run) rewind() error {
+	if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	r.r = bufio.NewReader(r.f)
+	return nil
+}
+
+func (r *run) next() error {
+	klen, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err // io.EOF at the end of the run
+	}
+	key := make([]byte, klen)
+	if _, err := io.ReadFull(r.r, key); err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	vlen, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	val := make([]byte, vlen)
+	if _, err := io.ReadFull(r.r, val); err != nil {
+		return fmt.Errorf("sort run: %w", err)
+	}
+	r.cur = pair{key: key, val: val}
+	return nil
+}
+
+// runHeap orders the runs by their current key
+type runHeap []*run
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return bytes.Compare(h[i].cur.key, h[j].cur.key) < 0 }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
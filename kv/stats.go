@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Stats is a cheap summary of the store, it doesn't walk the tree like
+// Check does.
+type Stats struct {
+	Version      uint64 // of the last commit
+	Pages        uint64 // in the file, the meta page included
+	FreePages    uint64 // in the freelist, waiting to be reused
+	Size         int64  // of the file in bytes, as fstat has it
+	Height       int    // levels of the tree, 0 for an empty one
+	Snapshots    int    // open ReadTx, Tx and iterators
+	Transactions int    // open read-write transactions
+}
+
+func (kv *KV) Stats() (*Stats, error) {
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	kv.mu.Lock()
+	if kv.closed {
+		kv.mu.Unlock()
+		return nil, ErrClosed
+	}
+	s := &Stats{
+		Version:   kv.version,
+		Pages:     kv.pages.flushed,
+		FreePages: kv.freelist.TailIdx - kv.freelist.HeadIdx,
+	}
+	for _, n := range kv.active {
+		s.Snapshots += n
+	}
+	for _, n := range kv.ongoing {
+		s.Transactions += n
+	}
+	// not Pages pages, a torn append or a failed commit leave more behind
+	var st syscall.Stat_t
+	err := syscall.Fstat(kv.fd, &st)
+	kv.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("fstat: %w", err)
+	}
+	s.Size = st.Size
+
+	height, err := kv.tree.Height()
+	if err != nil {
+		return nil, err
+	}
+	s.Height = height
+	return s, nil
+}
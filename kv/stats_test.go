@@ -0,0 +1,81 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "stats.db")
+	kv, err := Open(dbName, &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	s, err := kv.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Height != 0 || s.Snapshots != 0 || s.Size != int64(s.Pages)*4096 {
+		t.Fatalf("empty store: %+v", s)
+	}
+
+	for i := range 1000 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%04d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rtx, err := kv.BeginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := kv.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = kv.Stats()
+	tx.Abort() // before failing, Close waits for them
+	rtx.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != 1001 || s.Height < 2 || s.FreePages == 0 || s.Snapshots != 2 || s.Transactions != 1 {
+		t.Fatalf("after 1000 inserts: %+v", s)
+	}
+
+	r := mustCheck(t, kv)
+	if s, err = kv.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Pages != r.Pages || s.FreePages != uint64(r.Free) || s.Snapshots != 0 || s.Transactions != 0 {
+		t.Fatalf("stats %+v don't match check %+v", s, r)
+	}
+
+	// the size is the file's, whatever is past the last page included
+	kv.Close()
+	f, err := os.OpenFile(dbName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if kv, err = Open(dbName, &Options{NoSync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = kv.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Size != int64(s.Pages)*4096+1000 {
+		t.Fatalf("expected a size of %d got %d", s.Pages*4096+1000, s.Size)
+	}
+
+	kv.Close()
+	if _, err := kv.Stats(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package kv
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/GiorgosMarga/my_db/btree"
+)
+
+// Tx is a read-write transaction. It reads from the snapshot that was
+// committed when it began and keeps its writes in memory, so any number of
+// them can run concurrently. Commit checks that nothing the transaction read
+// was changed by a commit made in the meantime, then applies the writes to
+// the tree and makes them durable with a single updateFile.
+type Tx struct {
+	kv       *KV
+	snapshot *ReadTx
+	pending  map[string]pendingWrite
+	reads    []keyRange
+	done     bool
+}
+
+type pendingWrite struct {
+	val     []byte
+	deleted bool
+}
+
+// keyRange is the half-open range [start, end); a nil end is unbounded.
+type keyRange struct {
+	start []byte
+	end   []byte
+}
+
+// commitRecord is the sorted list of keys a commit wrote, nil if it wrote
+// every key.
+type commitRecord struct {
+	version uint64
+	writes  [][]byte
+}
+
+// Begin starts a read-write transaction on the last commit. It fails with
+// ErrReadOnly on a read-only store and ErrClosed after Close.
+func (kv *KV) Begin() (*Tx, error) {
+	if kv.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	snapshot, err := kv.newReadTx()
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{
+		kv:       kv,
+		snapshot: snapshot,
+		pending:  make(map[string]pendingWrite),
+	}
+	// registered together with the snapshot so the commits made after it
+	// stay in the history until this transaction is done
+	if kv.ongoing == nil {
+		kv.ongoing = make(map[uint64]int)
+	}
+	kv.ongoing[tx.snapshot.version]++
+	return tx, nil
+}
+
+// Get returns the value of k as seen by the transaction, including its own
+// writes. The slice is only valid until the transaction is done.
+func (tx *Tx) Get(k []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	tx.reads = append(tx.reads, keyRange{start: k, end: keyAfter(k)})
+
+	if w, ok := tx.pending[string(k)]; ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return w.val, nil
+	}
+	return tx.snapshot.Get(k)
+}
+
+func (tx *Tx) Set(k, v []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := btree.CheckSize(k, v); err != nil {
+		return err
+	}
+	tx.pending[string(k)] = pendingWrite{val: bytes.Clone(v)}
+	return nil
+}
+
+func (tx *Tx) Delete(k []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if len(k) == 0 {
+		return ErrEmptyKey
+	}
+	tx.pending[string(k)] = pendingWrite{deleted: true}
+	return nil
+}
+
+// Scan returns an iterator over [start, end) that merges the snapshot with
+// the transaction's own writes. The whole range counts as read.
+func (tx *Tx) Scan(start, end []byte) *Iterator {
+	return tx.scan(start, end, false)
+}
+
+func (tx *Tx) ScanReverse(start, end []byte) *Iterator {
+	return tx.scan(start, end, true)
+}
+
+func (tx *Tx) ScanPrefix(prefix []byte) *Iterator {
+	return tx.Scan(prefix, PrefixEnd(prefix))
+}
+
+func (tx *Tx) ScanPrefixReverse(prefix []byte) *Iterator {
+	return tx.ScanReverse(prefix, PrefixEnd(prefix))
+}
+
+func (tx *Tx) scan(start, end []byte, reverse bool) *Iterator {
+	tx.reads = append(tx.reads, keyRange{start: start, end: end})
+	pending := newPendingIter(tx.pending, start, end, reverse)
+	return newIterator(&tx.snapshot.tree, pending, start, end, reverse)
+}
+
+// Commit applies every write of the transaction at once. It returns
+// ErrConflict, and changes nothing, if a key or range the transaction read
+// was written by another transaction that committed after this one began.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	defer tx.end()
+
+	if len(tx.pending) == 0 {
+		return nil // read-only
+	}
+
+	kv := tx.kv
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+
+	if kv.conflicts(tx.snapshot.version, tx.reads) {
+		return ErrConflict
+	}
+
+	keys := make([][]byte, 0, len(tx.pending))
+	for k := range tx.pending {
+		keys = append(keys, []byte(k))
+	}
+	slices.SortFunc(keys, bytes.Compare)
+
+	return kv.commit(keys, func() (bool, error) {
+		return true, kv.applyAll(keys, tx.pending)
+	})
+}
+
+// commit runs apply on the tree and makes what it changed durable as one
+// commit that wrote keys, sorted, nil standing for every key. If apply
+// fails or reports no change the tree goes back to the last commit. The
+// writer lock must be held.
+func (kv *KV) commit(keys [][]byte, apply func() (bool, error)) error {
+	// readers that ended since the last commit no longer hold pages back
+	kv.mu.Lock()
+	closed := kv.closed
+	kv.freelist.SetMaxIdx()
+	kv.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	meta := kv.createMeta()
+	changed, err := apply()
+	if err != nil || !changed {
+		kv.revert(meta)
+		return err
+	}
+	if err := kv.updateOrRevert(meta); err != nil {
+		return err
+	}
+
+	// only transactions open now can conflict with this commit
+	kv.mu.Lock()
+	if len(kv.ongoing) > 0 {
+		kv.history = append(kv.history, commitRecord{version: kv.version, writes: keys})
+	}
+	kv.mu.Unlock()
+	return nil
+}
+
+// Abort drops every change of the transaction.
+func (tx *Tx) Abort() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.end()
+}
+
+func (tx *Tx) end() {
+	tx.snapshot.End()
+
+	kv := tx.kv
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.ongoing[tx.snapshot.version]--
+	if kv.ongoing[tx.snapshot.version] <= 0 {
+		delete(kv.ongoing, tx.snapshot.version)
+	}
+
+	// commits older than every open transaction can't conflict anymore
+	oldest := kv.snapshot.version
+	for version := range kv.ongoing {
+		oldest = min(oldest, version)
+	}
+	i := 0
+	for i < len(kv.history) && kv.history[i].version <= oldest {
+		i++
+	}
+	kv.history = kv.history[i:]
+}
+
+func (kv *KV) applyAll(keys [][]byte, pending map[string]pendingWrite) error {
+	for _, k := range keys {
+		if err := kv.apply(k, pending[string(k)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kv *KV) apply(k []byte, w pendingWrite) error {
+	req := &UpdateReq{Key: k, Val: w.val, Mode: MODE_UPSERT, Version: kv.version + 1, NoOld: true}
+	if w.deleted {
+		req.Mode = MODE_DELETE // deleting a missing key is fine
+	}
+	return kv.tree.Update(req)
+}
+
+// conflicts reports whether a commit made after version wrote a key inside
+// one of the read ranges.
+func (kv *KV) conflicts(version uint64, reads []keyRange) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	for _, commit := range kv.history {
+		if commit.version <= version {
+			continue
+		}
+		if commit.writes == nil {
+			return true // it wrote every key
+		}
+		for _, r := range reads {
+			i, _ := slices.BinarySearchFunc(commit.writes, r.start, bytes.Compare)
+			if i < len(commit.writes) && (r.end == nil || bytes.Compare(commit.writes[i], r.end) < 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyAfter returns the smallest key greater than k.
+func keyAfter(k []byte) []byte {
+	return append(bytes.Clone(k), 0)
+}
+
+// ReadTx is a read-only view of the last commit at the time BeginRead was
+// called. Writers keep going while it is open, the copy-on-write tree never
+// touches the pages it sees and the freelist holds them back until End.
+type ReadTx struct {
+	kv      *KV
+	tree    btree.Btree
+	pin     uint64
+	version uint64
+	done    bool
+}
+
+func (kv *KV) BeginRead() (*ReadTx, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.newReadTx()
+}
+
+// newReadTx must be called with mu held.
+func (kv *KV) newReadTx() (*ReadTx, error) {
+	if kv.closed {
+		return nil, ErrClosed
+	}
+	// the chunks only ever grow, a copy of the slice covers every page
+	// reachable from the snapshot root
+	chunks := kv.mmap.chunks
+	tx := &ReadTx{
+		kv:      kv,
+		pin:     kv.snapshot.tailIdx,
+		version: kv.snapshot.version,
+	}
+	tx.tree.Root = kv.snapshot.root
+	tx.tree.Get = func(ptr uint64) ([]byte, error) {
+		return readPage(chunks, ptr)
+	}
+	kv.freelist.AddReader(tx.pin)
+	if kv.active == nil {
+		kv.active = make(map[uint64]int)
+	}
+	kv.active[tx.version]++
+	kv.readers.Add(1)
+	return tx, nil
+}
+
+// Get returns the value of k. The slice is only valid until End.
+func (tx *ReadTx) Get(k []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.tree.GetValue(k)
+}
+
+// GetVersion returns the value of k and the version of the commit that
+// wrote it. The slice is only valid until End.
+func (tx *ReadTx) GetVersion(k []byte) ([]byte, uint64, error) {
+	if tx.done {
+		return nil, 0, ErrTxDone
+	}
+	return tx.tree.GetVersioned(k)
+}
+
+func (tx *ReadTx) Scan(start, end []byte) *Iterator {
+	return newIterator(&tx.tree, nil, start, end, false)
+}
+
+func (tx *ReadTx) ScanReverse(start, end []byte) *Iterator {
+	return newIterator(&tx.tree, nil, start, end, true)
+}
+
+func (tx *ReadTx) ScanPrefix(prefix []byte) *Iterator {
+	return tx.Scan(prefix, PrefixEnd(prefix))
+}
+
+func (tx *ReadTx) ScanPrefixReverse(prefix []byte) *Iterator {
+	return tx.ScanReverse(prefix, PrefixEnd(prefix))
+}
+
+// End releases the snapshot. Iterators and values obtained from the
+// transaction must not be used afterwards.
+func (tx *ReadTx) End() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	kv := tx.kv
+	kv.freelist.RemoveReader(tx.pin)
+
+	kv.mu.Lock()
+	kv.active[tx.version]--
+	if kv.active[tx.version] <= 0 {
+		delete(kv.active, tx.version)
+	}
+	kv.mu.Unlock()
+	kv.readers.Done()
+}
@@ -0,0 +1,400 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "tx.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := mustBegin(t, kv)
+	for i := range 1000 {
+		if err := tx.Set(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "v_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Delete([]byte("k_10")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := tx.Get([]byte("k_20"))
+	if err != nil || string(v) != "v_20" {
+		t.Fatalf("tx should see its own writes, got %s %v", v, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set([]byte("late"), []byte("v")); err != ErrTxDone {
+		t.Fatalf("expected ErrTxDone got %v", err)
+	}
+
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 1000 {
+		v, err := reopened.Get(fmt.Appendf(nil, "k_%d", i))
+		if i == 10 {
+			if err == nil {
+				t.Fatal("k_10 was deleted in the transaction")
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(v, fmt.Appendf(nil, "v_%d", i)) {
+			t.Fatalf("k_%d: got %s %v", i, v, err)
+		}
+	}
+}
+
+func TestTxAbort(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "tx_abort.db")
+	kv, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := mustBegin(t, kv)
+	for i := range 500 {
+		if err := tx.Set(fmt.Appendf(nil, "k_%d", i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx.Abort()
+
+	for i := range 500 {
+		v, err := kv.Get(fmt.Appendf(nil, "k_%d", i))
+		if i < 100 && (err != nil || string(v) != "old") {
+			t.Fatalf("k_%d: expected old got %s %v", i, v, err)
+		}
+		if i >= 100 && err == nil {
+			t.Fatalf("k_%d should not exist after abort", i)
+		}
+	}
+
+	// the store must still be writable after an abort
+	if err := kv.Insert([]byte("after"), []byte("abort")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := kv.Get([]byte("after"))
+	if err != nil || string(v) != "abort" {
+		t.Fatalf("got %s %v", v, err)
+	}
+}
+
+func TestTxAbortOnNewFile(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "tx_new.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := mustBegin(t, kv)
+	for i := range 300 {
+		if err := tx.Set(fmt.Appendf(nil, "k_%d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx.Abort()
+
+	for i := range 300 {
+		if err := kv.Insert(fmt.Appendf(nil, "k_%d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadTxSnapshot(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "snapshot.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numOfKeys := 200
+	writeGen := func(gen int) {
+		tx := mustBegin(t, kv)
+		for i := range numOfKeys {
+			if err := tx.Set(fmt.Appendf(nil, "k_%d", i), fmt.Appendf(nil, "gen_%d", gen)); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Error(err)
+		}
+	}
+	writeGen(0)
+
+	reader := mustBeginRead(t, kv)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for gen := 1; gen <= 20; gen++ {
+			writeGen(gen)
+		}
+	}()
+
+	// every read of the old snapshot keeps seeing generation 0 while the
+	// writer rewrites all keys and frees the old pages
+	for range 20 {
+		for i := range numOfKeys {
+			v, err := reader.Get(fmt.Appendf(nil, "k_%d", i))
+			if err != nil || string(v) != "gen_0" {
+				t.Fatalf("k_%d: expected gen_0 got %s %v", i, v, err)
+			}
+		}
+	}
+	<-done
+
+	count := 0
+	it := reader.Scan(nil, nil)
+	for ; it.Valid(); it.Next() {
+		if string(it.Value()) != "gen_0" {
+			t.Fatalf("%s: expected gen_0 got %s", it.Key(), it.Value())
+		}
+		count++
+	}
+	if count != numOfKeys {
+		t.Fatalf("expected %d keys got %d", numOfKeys, count)
+	}
+	reader.End()
+
+	v, err := kv.Get([]byte("k_0"))
+	if err != nil || string(v) != "gen_20" {
+		t.Fatalf("expected gen_20 got %s %v", v, err)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "readers.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("counter"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx, err := kv.BeginRead()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				a, errA := tx.Get([]byte("a"))
+				b, errB := tx.Get([]byte("b"))
+				// a and b are always written together
+				if (errA == nil) != (errB == nil) || !bytes.Equal(a, b) {
+					t.Errorf("torn read: %s %s", a, b)
+				}
+				tx.End()
+			}
+		}()
+	}
+
+	for i := range 200 {
+		tx := mustBegin(t, kv)
+		v := fmt.Appendf(nil, "v_%d", i)
+		if err := tx.Set([]byte("a"), v); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set([]byte("b"), v); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestTxConflict(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "conflict.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Insert([]byte("a"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	// both read a, the second to commit must fail
+	tx1, tx2 := mustBegin(t, kv), mustBegin(t, kv)
+	for _, tx := range []*Tx{tx1, tx2} {
+		if _, err := tx.Get([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set([]byte("a"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Commit(); err != ErrConflict {
+		t.Fatalf("expected ErrConflict got %v", err)
+	}
+
+	// blind writes and disjoint reads don't conflict
+	tx1, tx2 = mustBegin(t, kv), mustBegin(t, kv)
+	if _, err := tx1.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Set([]byte("b"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Set([]byte("c"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write inside a scanned range conflicts
+	tx1, tx2 = mustBegin(t, kv), mustBegin(t, kv)
+	it := tx1.Scan([]byte("b"), []byte("d"))
+	for ; it.Valid(); it.Next() {
+	}
+	if err := tx1.Set([]byte("z"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Set([]byte("bb"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != ErrConflict {
+		t.Fatalf("expected ErrConflict got %v", err)
+	}
+	if _, err := kv.Get([]byte("z")); err == nil {
+		t.Fatal("a conflicting tx must not write anything")
+	}
+}
+
+func TestTxScanPending(t *testing.T) {
+	kv := newScanDB(t) // a ab abc b ba c d
+
+	tx := mustBegin(t, kv)
+	defer tx.Abort()
+	for _, k := range []string{"aa", "c", "e"} {
+		if err := tx.Set([]byte(k), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range []string{"ab", "d", "missing"} {
+		if err := tx.Delete([]byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"a", "aa", "abc", "b", "ba", "c", "e"}
+	if got := collect(tx.Scan(nil, nil)); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v got %v", expected, got)
+	}
+	slices.Reverse(expected)
+	if got := collect(tx.ScanReverse(nil, nil)); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v got %v", expected, got)
+	}
+	if got := collect(tx.Scan([]byte("ab"), []byte("c"))); fmt.Sprint(got) != "[abc b ba]" {
+		t.Fatalf("got %v", got)
+	}
+
+	it := tx.Scan([]byte("c"), nil)
+	if !it.Valid() || string(it.Value()) != "new" {
+		t.Fatalf("pending value should shadow the tree, got %s", it.Value())
+	}
+}
+
+func TestConcurrentTxCounter(t *testing.T) {
+	kv, err := Open(filepath.Join(t.TempDir(), "counter.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workers, increments := 8, 25
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				for {
+					tx, err := kv.Begin()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n := 0
+					if v, err := tx.Get([]byte("counter")); err == nil {
+						n, _ = strconv.Atoi(string(v))
+					}
+					if err := tx.Set([]byte("counter"), strconv.AppendInt(nil, int64(n+1), 10)); err != nil {
+						t.Error(err)
+					}
+					err = tx.Commit()
+					if err == nil {
+						break
+					}
+					if err != ErrConflict {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, err := kv.Get([]byte("counter"))
+	if err != nil || string(v) != strconv.Itoa(workers*increments) {
+		t.Fatalf("expected %d got %s %v", workers*increments, v, err)
+	}
+	if len(kv.history) != 0 {
+		t.Fatalf("history should be empty without open transactions, has %d", len(kv.history))
+	}
+}
+
+func mustBegin(t *testing.T, kv *KV) *Tx {
+	t.Helper()
+	tx, err := kv.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func mustBeginRead(t *testing.T, kv *KV) *ReadTx {
+	t.Helper()
+	tx, err := kv.BeginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
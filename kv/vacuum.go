@@ -0,0 +1,311 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/btree"
+	"github.com/GiorgosMarga/my_db/freelist"
+)
+
+// VacuumStats reports what Vacuum did.
+type VacuumStats struct {
+	Moved      int // pages of the tree copied further down the file
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Vacuum gives the free pages at the end of the file back to the OS while
+// the store stays open. It copies the pages of the tree that sit past the
+// point the file can be cut at into free pages below it, in a commit of
+// its own, waits for the transactions that began before to end and then
+// truncates the file. Pages held back by open transactions can't move, and
+// if a commit has to grow the file while Vacuum waits the pages are only
+// made free again.
+//
+// With NoLock the file is never cut: readers in other processes may have
+// the end of it mapped, and touching a page past a truncate kills them
+// with SIGBUS. Vacuum then does nothing.
+func (kv *KV) Vacuum() (*VacuumStats, error) {
+	if kv.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if kv.opts.NoLock {
+		kv.writer.Lock()
+		defer kv.writer.Unlock()
+		size := int64(kv.pages.flushed) * btree.BNODE_PAGE_SIZE
+		return &VacuumStats{SizeBefore: size, SizeAfter: size}, nil
+	}
+
+	kv.writer.Lock()
+	end := kv.pages.flushed
+	stats := &VacuumStats{SizeBefore: int64(end) * btree.BNODE_PAGE_SIZE}
+	cut := end
+	err := kv.commit([][]byte{}, func() (bool, error) {
+		var err error
+		cut, stats.Moved, err = kv.relocate()
+		return cut < end, err
+	})
+	version := kv.version
+	kv.writer.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	stats.SizeAfter = stats.SizeBefore
+	if cut >= end {
+		return stats, nil
+	}
+
+	// older snapshots may still read the pages that were moved
+	if err := kv.waitSnapshots(version); err != nil {
+		return nil, err
+	}
+
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+	if kv.pages.flushed != end {
+		// the file grew past the pages the move left behind, they go back
+		// to the freelist instead
+		stats.SizeAfter = int64(kv.pages.flushed) * btree.BNODE_PAGE_SIZE
+		return stats, kv.commit([][]byte{}, func() (bool, error) {
+			for ptr := cut; ptr < end; ptr++ {
+				if err := kv.freelist.PushTail(ptr); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		})
+	}
+	err = kv.commit([][]byte{}, func() (bool, error) {
+		kv.pages.flushed = cut
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := kv.truncate(cut); err != nil {
+		return nil, err
+	}
+	stats.SizeAfter = int64(cut) * btree.BNODE_PAGE_SIZE
+	return stats, nil
+}
+
+// relocate picks the lowest page the file can be cut at, copies the pages
+// of the tree past it into free pages below it and makes a new freelist
+// out of the free pages left below it. It returns the cut, the end of the
+// file if nothing can be gained, and the number of pages moved.
+func (kv *KV) relocate() (uint64, int, error) {
+	end := kv.pages.flushed
+	fl := &kv.freelist
+
+	// free pages can be written right away, pinned ones are still seen by
+	// open snapshots and stay where they are
+	var free, pinned []uint64
+	lists, err := fl.Walk(func(idx, ptr uint64) error {
+		if idx-fl.HeadIdx < fl.MaxIdx-fl.HeadIdx {
+			free = append(free, ptr)
+		} else {
+			pinned = append(pinned, ptr)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	slices.Sort(free)
+
+	type treePage struct {
+		ptr    uint64
+		parent int
+	}
+	var pages []treePage
+	index := make(map[uint64]int)
+	err = kv.tree.Walk(func(ptr, parent uint64) error {
+		p := -1
+		if parent != 0 {
+			p = index[parent]
+		}
+		index[ptr] = len(pages)
+		pages = append(pages, treePage{ptr: ptr, parent: p})
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the pages written to cut at cut: the ones past it and their parents
+	copies := func(cut uint64) int {
+		copied := make([]bool, len(pages))
+		n := 0
+		for i := len(pages) - 1; i >= 0; i-- {
+			if pages[i].ptr >= cut {
+				copied[i] = true
+			}
+			if copied[i] {
+				n++
+				if pages[i].parent >= 0 {
+					copied[pages[i].parent] = true
+				}
+			}
+		}
+		return n
+	}
+	freeBelow := func(cut uint64) int {
+		return sort.Search(len(free), func(i int) bool { return free[i] >= cut })
+	}
+	// enough to hold every page of the new list in any layout
+	listPages := (len(free)+len(lists)+len(pinned)+len(pages))/freelist.MAX_PTRS + 2
+
+	lo := uint64(1)
+	for _, ptr := range pinned {
+		lo = max(lo, ptr+1)
+	}
+	if lo >= end {
+		return end, 0, nil
+	}
+	cut := lo + uint64(sort.Search(int(end-lo), func(i int) bool {
+		cut := lo + uint64(i)
+		return freeBelow(cut) >= copies(cut)+listPages
+	}))
+	if cut >= end {
+		return end, 0, nil
+	}
+
+	pool := free[:freeBelow(cut)]
+	take := func(data []byte) (uint64, error) {
+		if len(pool) == 0 {
+			return 0, fmt.Errorf("vacuum: out of free pages below %d", cut)
+		}
+		ptr := pool[0]
+		pool = pool[1:]
+		kv.pages.updated[ptr] = data
+		return ptr, nil
+	}
+	var moved []uint64
+	tree := kv.tree
+	tree.New = take
+	tree.Del = func(ptr uint64) error {
+		moved = append(moved, ptr)
+		return nil
+	}
+	if err := tree.Relocate(func(ptr uint64) bool { return ptr >= cut }); err != nil {
+		return 0, 0, err
+	}
+	kv.tree.Root = tree.Root
+
+	// the pages of the old list can't be written before the commit, they go
+	// in the new one next to the free pages. The pages snapshots still see
+	// go last, from where they were pinned on.
+	var safe, held []uint64
+	for _, ptr := range lists {
+		if ptr < cut {
+			safe = append(safe, ptr)
+		}
+	}
+	held = append(held, pinned...)
+	for _, ptr := range moved {
+		if ptr < cut {
+			held = append(held, ptr)
+		}
+	}
+
+	nodes, start, ok := listLayout(len(pool), len(safe), len(held), fl.MaxIdx)
+	if !ok {
+		return end, 0, nil
+	}
+	safe = append(safe, pool[nodes:]...)
+	pool = pool[:nodes]
+
+	head, err := take(make([]byte, btree.BNODE_PAGE_SIZE))
+	if err != nil {
+		return 0, 0, err
+	}
+	fl.Reset(head, start)
+	appendPage := fl.New
+	fl.New = take
+	defer func() { fl.New = appendPage }()
+	for _, ptr := range append(safe, held...) {
+		if err := fl.PushTail(ptr); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(pool) != 0 {
+		return 0, 0, fmt.Errorf("vacuum: %d freelist pages left over", len(pool))
+	}
+	return cut, len(moved), nil
+}
+
+// listLayout picks how many of the writable free pages make up the pages
+// of the new freelist, so that every other free page is an entry and none
+// is left over, and the index it starts at. The safe entries, the rest of
+// the writable pages and the old list pages, end at maxIdx when they can
+// so they are reusable at once, the held ones start at maxIdx or later.
+func listLayout(writable, lists, held int, maxIdx uint64) (int, uint64, bool) {
+	for nodes := 1; nodes <= writable; nodes++ {
+		safe := uint64(writable - nodes + lists)
+		entries := safe + uint64(held)
+		lo := uint64(0)
+		if maxIdx > safe {
+			lo = maxIdx - safe
+		}
+		for start := lo; start <= min(lo+1, maxIdx); start++ {
+			if (start%freelist.MAX_PTRS+entries)/freelist.MAX_PTRS+1 == uint64(nodes) {
+				return nodes, start, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// waitSnapshots waits for every snapshot older than version to end.
+func (kv *KV) waitSnapshots(version uint64) error {
+	for {
+		kv.mu.Lock()
+		closed, busy := kv.closed, false
+		for v := range kv.active {
+			busy = busy || v < version
+		}
+		kv.mu.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		if !busy {
+			return nil
+		}
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+}
+
+// truncate cuts the file down to n pages and unmaps the chunks that lie
+// entirely past them. Nothing may read past n pages anymore.
+func (kv *KV) truncate(n uint64) error {
+	size := n * btree.BNODE_PAGE_SIZE
+	if err := syscall.Ftruncate(kv.fd, int64(size)); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	if err := kv.sync(); err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var errs []error
+	start, keep := uint64(0), 0
+	for _, chunk := range kv.mmap.chunks {
+		if keep > 0 && start >= size {
+			if err := syscall.Munmap(chunk); err != nil {
+				errs = append(errs, fmt.Errorf("munmap: %w", err))
+			}
+			continue
+		}
+		start += uint64(len(chunk))
+		keep++
+	}
+	kv.mmap.chunks = kv.mmap.chunks[:keep:keep]
+	kv.mmap.size = start
+	return errors.Join(errs...)
+}
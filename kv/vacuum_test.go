@@ -0,0 +1,223 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newVacuumDB leaves the start of the file free and the live keys at its
+// end, including a value in overflow pages.
+func newVacuumDB(t *testing.T) (*KV, string) {
+	t.Helper()
+	dbName := filepath.Join(t.TempDir(), "vacuum.db")
+	kv, err := Open(dbName, &Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("v"), 100)
+	for i := range 3000 {
+		if err := kv.Insert(fmt.Appendf(nil, "a_%05d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 300 {
+		if err := kv.Insert(fmt.Appendf(nil, "z_%05d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.Insert([]byte("zz"), bytes.Repeat([]byte("big "), 5000)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3000 {
+		if err := kv.Delete(fmt.Appendf(nil, "a_%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kv, dbName
+}
+
+func checkVacuumDB(t *testing.T, kv *KV) {
+	t.Helper()
+	got := collect(kv.Scan(nil, nil))
+	if len(got) != 301 || got[0] != "z_00000" || got[300] != "zz" {
+		t.Fatalf("unexpected keys: %d", len(got))
+	}
+	if v, err := kv.Get([]byte("zz")); err != nil || !bytes.Equal(v, bytes.Repeat([]byte("big "), 5000)) {
+		t.Fatalf("zz: %d bytes %v", len(v), err)
+	}
+
+	// every page is used exactly once, by the tree or the freelist
+	seen := make(map[uint64]int)
+	seen[0]++
+	if err := kv.tree.Walk(func(ptr, _ uint64) error {
+		seen[ptr]++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	lists, err := kv.freelist.Walk(func(_, ptr uint64) error {
+		seen[ptr]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ptr := range lists {
+		seen[ptr]++
+	}
+	for ptr := range kv.pages.flushed {
+		if seen[ptr] != 1 {
+			t.Fatalf("page %d is used %d times", ptr, seen[ptr])
+		}
+	}
+	if len(seen) != int(kv.pages.flushed) {
+		t.Fatalf("%d pages used in a file of %d", len(seen), kv.pages.flushed)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	kv, dbName := newVacuumDB(t)
+	stats, err := kv.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Moved == 0 || stats.SizeAfter >= stats.SizeBefore/4 {
+		t.Fatalf("expected the file to shrink: %+v", stats)
+	}
+	if fi, err := os.Stat(dbName); err != nil || fi.Size() != stats.SizeAfter {
+		t.Fatalf("size of the file: %v %v", fi, err)
+	}
+	checkVacuumDB(t, kv)
+
+	// nothing left to gain
+	again, err := kv.Vacuum()
+	if err != nil || again.SizeAfter != stats.SizeAfter {
+		t.Fatalf("second vacuum: %+v %v", again, err)
+	}
+
+	// the store keeps growing from the new end
+	for i := range 1000 {
+		if err := kv.Insert(fmt.Appendf(nil, "b_%05d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 1000 {
+		if err := kv.Delete(fmt.Appendf(nil, "b_%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkVacuumDB(t, kv)
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkVacuumDB(t, reopened)
+}
+
+func TestVacuumWaitsForReaders(t *testing.T) {
+	kv, _ := newVacuumDB(t)
+	defer kv.Close()
+
+	reader := mustBeginRead(t, kv)
+	done := make(chan vacuumResult)
+	go func() {
+		stats, err := kv.Vacuum()
+		done <- vacuumResult{stats, err}
+	}()
+	select {
+	case res := <-done:
+		t.Fatalf("Vacuum returned with an older snapshot open: %v", res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the pages moved away are still there for the snapshot
+	if v, err := reader.Get([]byte("z_00299")); err != nil || len(v) != 100 {
+		t.Fatalf("z_00299: %q %v", v, err)
+	}
+	reader.End()
+	if stats := <-done; stats.err != nil || stats.SizeAfter >= stats.SizeBefore/4 {
+		t.Fatalf("expected the file to shrink: %+v", stats)
+	}
+	checkVacuumDB(t, kv)
+}
+
+func TestVacuumGrowWhileWaiting(t *testing.T) {
+	kv, _ := newVacuumDB(t)
+	defer kv.Close()
+
+	reader := mustBeginRead(t, kv)
+	done := make(chan vacuumResult)
+	go func() {
+		stats, err := kv.Vacuum()
+		done <- vacuumResult{stats, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// writers aren't held up, with the free pages pinned by the reader
+	// they have to append
+	for i := range 100 {
+		if err := kv.Insert(fmt.Appendf(nil, "b_%05d", i), bytes.Repeat([]byte("v"), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 100 {
+		if err := kv.Delete(fmt.Appendf(nil, "b_%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reader.End()
+	if stats := <-done; stats.err != nil || stats.SizeAfter < stats.SizeBefore {
+		t.Fatalf("the file can't shrink: %+v", stats)
+	}
+	checkVacuumDB(t, kv)
+
+	// the pages left behind were freed, the next run gets them back
+	stats, err := kv.Vacuum()
+	if err != nil || stats.SizeAfter >= stats.SizeBefore/4 {
+		t.Fatalf("expected the file to shrink: %+v %v", stats, err)
+	}
+	checkVacuumDB(t, kv)
+}
+
+type vacuumResult struct {
+	*VacuumStats
+	err error
+}
+
+func TestVacuumNoLock(t *testing.T) {
+	kv, dbName := newVacuumDB(t)
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := Open(dbName, &Options{NoLock: true, NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	before, err := os.Stat(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := kv.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.SizeAfter != stats.SizeBefore || after.Size() != before.Size() {
+		t.Fatalf("the file was cut without a lock: %+v, %d bytes then %d", stats, before.Size(), after.Size())
+	}
+	checkVacuumDB(t, kv)
+}
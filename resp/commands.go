@@ -0,0 +1,472 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+// getter is what the reading commands need, the store outside of a MULTI
+// and the transaction of the batch inside one.
+type getter interface {
+	Get(k []byte) ([]byte, error)
+}
+
+// command is one of read, write and conn. Commands that only read run on
+// the connection's goroutine, writes are queued to the writer and conn
+// commands deal with the connection or the server and can't be queued in a
+// MULTI.
+type command struct {
+	arity int // arguments with the name, -n for at least n
+	read  func(g getter, args [][]byte) any
+	write func(tx *kv.Tx, args [][]byte) any
+	conn  func(c *conn, args [][]byte) any
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {arity: -1, read: ping},
+		"echo":    {arity: 2, read: echo},
+		"get":     {arity: 2, read: get},
+		"exists":  {arity: -2, read: exists},
+		"set":     {arity: -3, write: set},
+		"del":     {arity: -2, write: del},
+		"scan":    {arity: -2, conn: scan},
+		"info":    {arity: -1, conn: info},
+		"select":  {arity: 2, conn: selectDB},
+		"quit":    {arity: 1, conn: reply(okReply)},
+		"client":  {arity: -2, conn: reply(okReply)},
+		"command": {arity: -1, conn: reply([]any{})}, // sent by redis-cli on start
+	}
+}
+
+func (cmd command) arityOK(n int) bool {
+	if cmd.arity < 0 {
+		return n >= -cmd.arity
+	}
+	return n == cmd.arity
+}
+
+// handle runs a command, or queues it inside a MULTI, and returns its reply.
+func (c *conn) handle(args [][]byte) any {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "multi":
+		if c.multi {
+			return respError("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		return okReply
+	case "exec":
+		if !c.multi {
+			return respError("ERR EXEC without MULTI")
+		}
+		return c.exec()
+	case "discard":
+		if !c.multi {
+			return respError("ERR DISCARD without MULTI")
+		}
+		c.multi, c.queued, c.dirty = false, nil, false
+		return okReply
+	}
+
+	cmd, found := commands[name]
+	var refused any
+	switch {
+	case !found:
+		refused = respError(fmt.Sprintf("ERR unknown command '%s'", oneLine(string(args[0]))))
+	case !cmd.arityOK(len(args)):
+		refused = respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	case c.multi && cmd.conn != nil:
+		refused = respError(fmt.Sprintf("ERR '%s' is not allowed inside MULTI", name))
+	}
+	if refused != nil {
+		c.dirty = c.multi
+		return refused
+	}
+
+	switch {
+	case c.multi:
+		c.queued = append(c.queued, args)
+		return simple("QUEUED")
+	case cmd.read != nil:
+		return cmd.read(c.s.db, args)
+	case cmd.write != nil:
+		return c.s.submit(func(tx *kv.Tx) any {
+			return cmd.write(tx, args)
+		})
+	}
+	return cmd.conn(c, args)
+}
+
+// exec runs the commands queued since MULTI in one transaction, the one of
+// the writer's batch, and replies with the array of their replies.
+func (c *conn) exec() any {
+	queued, dirty := c.queued, c.dirty
+	c.multi, c.queued, c.dirty = false, nil, false
+	if dirty {
+		return respError("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return c.s.submit(func(tx *kv.Tx) any {
+		replies := make([]any, len(queued))
+		for i, args := range queued {
+			cmd := commands[strings.ToLower(string(args[0]))]
+			switch {
+			case cmd.read != nil:
+				replies[i] = cmd.read(tx, args)
+			case cmd.write != nil:
+				replies[i] = cmd.write(tx, args)
+			default:
+				replies[i] = respError(fmt.Sprintf("ERR unknown command '%s'", oneLine(string(args[0]))))
+			}
+		}
+		return replies
+	})
+}
+
+func reply(v any) func(*conn, [][]byte) any {
+	return func(*conn, [][]byte) any {
+		return v
+	}
+}
+
+func ping(_ getter, args [][]byte) any {
+	if len(args) > 2 {
+		return respError("ERR wrong number of arguments for 'ping' command")
+	}
+	if len(args) == 2 {
+		return args[1]
+	}
+	return simple("PONG")
+}
+
+func echo(_ getter, args [][]byte) any {
+	return args[1]
+}
+
+func get(g getter, args [][]byte) any {
+	v, err := g.Get(args[1])
+	if errors.Is(err, kv.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return bytes.Clone(v) // a transaction's value goes away with it
+}
+
+func exists(g getter, args [][]byte) any {
+	n := 0
+	for _, k := range args[1:] {
+		_, err := g.Get(k)
+		if errors.Is(err, kv.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	return n
+}
+
+// set supports the NX and XX options, replying with the null bulk string
+// when they leave the key alone. Keys don't expire.
+func set(tx *kv.Tx, args [][]byte) any {
+	nx, xx := false, false
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(string(opt)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX", "EXAT", "PXAT", "KEEPTTL":
+			return respError("ERR expiration is not supported")
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return respError("ERR syntax error")
+	}
+	k, v := args[1], args[2]
+	if nx || xx {
+		_, err := tx.Get(k)
+		if err != nil && !errors.Is(err, kv.ErrNotFound) {
+			return err
+		}
+		if exists := err == nil; nx && exists || xx && !exists {
+			return nil
+		}
+	}
+	if err := tx.Set(k, v); err != nil {
+		return err
+	}
+	return okReply
+}
+
+func del(tx *kv.Tx, args [][]byte) any {
+	n := 0
+	for _, k := range args[1:] {
+		_, err := tx.Get(k)
+		if errors.Is(err, kv.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(k); err != nil {
+			return err
+		}
+		n++
+	}
+	return n
+}
+
+func selectDB(_ *conn, args [][]byte) any {
+	if string(args[1]) != "0" {
+		return respError("ERR DB index is out of range")
+	}
+	return okReply
+}
+
+const (
+	DEFAULT_SCAN_COUNT = 10
+	// MAX_CURSORS is how many SCAN cursors are remembered, older ones are
+	// refused.
+	MAX_CURSORS = 1 << 16
+)
+
+// cursors maps the numbers handed out as SCAN cursors to the key the scan
+// resumes at. Clients parse cursors as integers so the keys themselves
+// can't be sent back. Numbers start at random so that cursors of an
+// earlier run of the server aren't mistaken for new ones.
+type cursors struct {
+	mu   sync.Mutex
+	last uint64
+	keys map[uint64][]byte
+}
+
+func (cs *cursors) put(key []byte) uint64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.keys == nil {
+		cs.keys = make(map[uint64][]byte)
+		cs.last = rand.Uint64() >> 2 // far from wrapping around to 0
+	}
+	cs.last++
+	cs.keys[cs.last] = key
+	delete(cs.keys, cs.last-MAX_CURSORS)
+	return cs.last
+}
+
+func (cs *cursors) get(id uint64) ([]byte, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	key, found := cs.keys[id]
+	return key, found
+}
+
+// scan walks the keys in order, COUNT at a time, keeping those that MATCH.
+// A scan started from cursor 0 sees every key present for its whole run,
+// it resumes after the last key it looked at rather than at a position.
+func scan(c *conn, args [][]byte) any {
+	id, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return respError("ERR invalid cursor")
+	}
+	var pattern []byte
+	count := DEFAULT_SCAN_COUNT
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return respError("ERR syntax error")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				return respError("ERR value is out of range, must be positive")
+			}
+		case "TYPE":
+			if !strings.EqualFold(string(args[i+1]), "string") {
+				return []any{"0", []any{}} // every key is a string
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	prefix := literalPrefix(pattern)
+	start := prefix
+	if id != 0 {
+		key, found := c.s.cursors.get(id)
+		if !found {
+			return respError("ERR invalid cursor")
+		}
+		start = key
+	}
+	it := c.s.db.Scan(start, nil)
+	defer it.Close()
+
+	keys := []any{}
+	for n := 0; n < count && it.Valid() && bytes.HasPrefix(it.Key(), prefix); n++ {
+		if pattern == nil || match(pattern, it.Key()) {
+			keys = append(keys, bytes.Clone(it.Key()))
+		}
+		it.Next()
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	next := uint64(0)
+	if it.Valid() && bytes.HasPrefix(it.Key(), prefix) {
+		next = c.s.cursors.put(bytes.Clone(it.Key()))
+	}
+	return []any{strconv.FormatUint(next, 10), keys}
+}
+
+// literalPrefix returns the part of a glob pattern before its first
+// special character, every key it matches starts with it.
+func literalPrefix(pattern []byte) []byte {
+	if i := bytes.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// match reports whether s matches the glob pattern the way redis does: *
+// and ? match any bytes, [abc], [^abc] and [a-z] a set of them and \
+// escapes the character after it.
+func match(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := range len(s) + 1 {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var in bool
+			in, pattern = matchSet(pattern[1:], s[0])
+			if !in {
+				return false
+			}
+			s = s[1:]
+			continue
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchSet reports whether b is in the set at the start of pattern, just
+// after its '[', and returns the rest of the pattern after the set. An
+// unterminated set runs to the end of the pattern.
+func matchSet(pattern []byte, b byte) (bool, []byte) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	in := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			in = in || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			in = in || lo <= b && b <= hi
+			pattern = pattern[3:]
+		default:
+			in = in || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // the ']'
+	}
+	return in != not, pattern
+}
+
+// info replies with the sections of INFO redis clients know, server,
+// clients and stats, and a storage section with kv.Stats.
+func info(c *conn, args [][]byte) any {
+	section := "all"
+	if len(args) > 1 {
+		section = strings.ToLower(string(args[1]))
+	}
+	s := c.s
+	var b strings.Builder
+	add := func(name string, lines ...string) {
+		if section != "all" && section != "default" && section != "everything" && section != name {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(name[:1]) + name[1:] + "\r\n")
+		for _, line := range lines {
+			b.WriteString(line + "\r\n")
+		}
+	}
+
+	add("server",
+		"redis_version:7.0.0", // clients look for it
+		"server_name:mydb",
+		fmt.Sprintf("uptime_in_seconds:%d", int(time.Since(s.started).Seconds())))
+	add("clients",
+		fmt.Sprintf("connected_clients:%d", s.clients()))
+	add("stats",
+		fmt.Sprintf("total_connections_received:%d", s.stats.connections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", s.stats.commands.Load()),
+		fmt.Sprintf("total_commits:%d", s.stats.commits.Load()))
+
+	st, err := s.db.Stats()
+	if err != nil {
+		return err
+	}
+	add("storage",
+		fmt.Sprintf("version:%d", st.Version),
+		fmt.Sprintf("pages:%d", st.Pages),
+		fmt.Sprintf("file_size:%d", st.Size),
+		fmt.Sprintf("free_pages:%d", st.FreePages),
+		fmt.Sprintf("tree_height:%d", st.Height),
+		fmt.Sprintf("open_snapshots:%d", st.Snapshots),
+		fmt.Sprintf("open_transactions:%d", st.Transactions))
+	return b.String()
+}
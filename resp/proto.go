@@ -0,0 +1,167 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+const (
+	// MAX_BULK_SIZE is the biggest argument a client may send, the same
+	// default as redis.
+	MAX_BULK_SIZE = 512 << 20
+	MAX_ARGS      = 1 << 20
+	// PREALLOC_ARGS is the most args room is made for before they are
+	// read.
+	PREALLOC_ARGS = 64
+	// MAX_INLINE_SIZE is the longest inline command, it must fit in the
+	// buffer of the reader.
+	MAX_INLINE_SIZE = 64 << 10
+)
+
+// errProtocol is returned by readCommand for a request that can't be
+// parsed, the connection can't be trusted to be in sync afterwards.
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command sent as an array of bulk strings, the way
+// clients send them, or inline as a line of space separated words, the
+// way one is typed in telnet. An empty inline line is an empty command.
+// The args are the caller's, they don't share the reader's buffer.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(bytes.Clone(line)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > MAX_ARGS {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	// the count is the client's word, append grows the slice as the args
+	// actually come in
+	args := make([][]byte, 0, min(max(n, 0), PREALLOC_ARGS))
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > MAX_BULK_SIZE {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		arg, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readLine returns the next line without its \r\n, the slice is only valid
+// until the next read.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+// readBulk reads size bytes and the \r\n after them. The buffer grows as
+// the data comes in rather than trusting the size up front.
+func readBulk(r *bufio.Reader, size int) ([]byte, error) {
+	const chunk = 64 << 10
+	buf := make([]byte, 0, min(size, chunk))
+	for len(buf) < size {
+		n := min(size-len(buf), chunk)
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(r, buf[len(buf)-n:]); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	var end [2]byte
+	if _, err := io.ReadFull(r, end[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	if end != [2]byte{'\r', '\n'} {
+		return nil, fmt.Errorf("%w: bulk string not followed by CRLF", errProtocol)
+	}
+	return buf, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// The replies of the commands, written by writeReply. A nil reply is the
+// null bulk string, []byte a bulk string and []any an array.
+type (
+	simple string
+	// respError is an error reply sent as is, other errors get the
+	// generic ERR prefix.
+	respError string
+)
+
+const okReply = simple("OK")
+
+// writeReply appends the encoding of v to w.
+func writeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case simple:
+		w.WriteString("+" + string(v) + "\r\n")
+	case respError:
+		w.WriteString("-" + string(v) + "\r\n")
+	case error:
+		w.WriteString("-ERR " + oneLine(v.Error()) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case string:
+		writeReply(w, []byte(v))
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	default:
+		// a bug in a command, the client still gets a reply so the
+		// connection stays in sync
+		log.Printf("resp: reply of type %T", v)
+		w.WriteString("-ERR internal error\r\n")
+	}
+}
+
+// oneLine keeps an error message from breaking the framing.
+func oneLine(s string) string {
+	return string(bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, []byte(s)))
+}
@@ -0,0 +1,111 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	in := "*3\r\n$3\r\nSET\r\n$5\r\na\r\nb \r\n$0\r\n\r\n" +
+		"GET  key\r\n" +
+		"\r\n" +
+		"PING\n"
+	r := bufio.NewReader(strings.NewReader(in))
+	expected := [][]string{{"SET", "a\r\nb ", ""}, {"GET", "key"}, {}, {"PING"}}
+	for _, e := range expected {
+		args, err := readCommand(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", args) != fmt.Sprintf("%q", e) {
+			t.Fatalf("expected %q got %q", e, args)
+		}
+	}
+	if _, err := readCommand(r); err != io.EOF {
+		t.Fatalf("expected io.EOF got %v", err)
+	}
+
+	for _, bad := range []string{
+		"*x\r\n",
+		"*1\r\n:1\r\n",
+		"*1\r\n$-2\r\n",
+		"*1\r\n$3\r\nabcd\r\n",
+		fmt.Sprintf("*1\r\n$%d\r\n", MAX_BULK_SIZE+1),
+	} {
+		_, err := readCommand(bufio.NewReader(strings.NewReader(bad)))
+		if !errors.Is(err, errProtocol) {
+			t.Fatalf("%q: expected a protocol error got %v", bad, err)
+		}
+	}
+	if _, err := readCommand(bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$10\r\nab"))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF got %v", err)
+	}
+	// a big count with nothing behind it doesn't make room for the args
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := readCommand(bufio.NewReader(strings.NewReader(fmt.Sprintf("*%d\r\n", MAX_ARGS)))); err != io.EOF {
+		t.Fatalf("expected io.EOF got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes for a command that never came", n)
+	}
+	long := bufio.NewReaderSize(strings.NewReader(strings.Repeat("a", 100)+"\r\n"), 16)
+	if _, err := readCommand(long); !errors.Is(err, errProtocol) {
+		t.Fatalf("expected a protocol error for a long inline command got %v", err)
+	}
+}
+
+func TestWriteReply(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	writeReply(w, []any{okReply, nil, 3, []byte("ab"), "c", errors.New("bad\r\nthing"), respError("EXECABORT x"), []any{}, 1.5})
+	w.Flush()
+	expected := "*9\r\n+OK\r\n$-1\r\n:3\r\n$2\r\nab\r\n$1\r\nc\r\n-ERR bad  thing\r\n-EXECABORT x\r\n*0\r\n-ERR internal error\r\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q got %q", expected, b.String())
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "usr:1", false},
+		{"*:1", "user:1", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"a**", "abc", true},
+	}
+	for _, tt := range tests {
+		if got := match([]byte(tt.pattern), []byte(tt.s)); got != tt.expected {
+			t.Fatalf("match(%q, %q): expected %v", tt.pattern, tt.s, tt.expected)
+		}
+	}
+	if p := literalPrefix([]byte("user:*:name")); string(p) != "user:" {
+		t.Fatalf("literal prefix %q", p)
+	}
+}
@@ -0,0 +1,292 @@
+// Package resp serves a kv.KV to redis clients over a subset of RESP2,
+// the redis protocol.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+const (
+	// MAX_BATCH is how many queued writes go in one commit at most.
+	MAX_BATCH = 128
+	// MAX_RETRIES is how many times a batch is run when its commit
+	// conflicts.
+	MAX_RETRIES = 3
+)
+
+// Server serves the keys of a store. Each connection is served by its own
+// goroutine, reads go straight to the store while writes are queued to a
+// single writer that commits them in batches, one transaction for all the
+// writes waiting when it gets to them.
+type Server struct {
+	db    *kv.KV
+	queue chan *write
+	quit  chan struct{}
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	handlers  sync.WaitGroup
+	writerWG  sync.WaitGroup
+
+	cursors cursors
+	started time.Time
+	stats   struct {
+		connections atomic.Int64 // accepted since the start
+		commands    atomic.Int64
+		commits     atomic.Int64
+	}
+}
+
+// write is a queued write, apply runs in the transaction of the batch and
+// returns the reply.
+type write struct {
+	apply func(tx *kv.Tx) any
+	reply chan any
+}
+
+// NewServer returns a server for db, which stays owned by the caller and
+// must outlive it.
+func NewServer(db *kv.KV) *Server {
+	s := &Server{
+		db:        db,
+		queue:     make(chan *write),
+		quit:      make(chan struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		started:   time.Now(),
+	}
+	s.writerWG.Add(1)
+	go s.writer()
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close, then returns
+// ErrServerClosed. l is closed when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+		s.stats.connections.Add(1)
+		go s.serveConn(c)
+	}
+}
+
+// Close stops the listeners, closes every connection and waits for the
+// writes already queued to be committed. It doesn't close the store.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	close(s.quit)
+	s.writerWG.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *Server) clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// conn is the state of a connection between commands.
+type conn struct {
+	s      *Server
+	w      *bufio.Writer
+	multi  bool       // inside MULTI
+	queued [][][]byte // commands of the MULTI
+	dirty  bool       // a command of the MULTI was refused, EXEC fails
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+		s.handlers.Done()
+	}()
+
+	r := bufio.NewReaderSize(nc, MAX_INLINE_SIZE)
+	c := &conn{s: s, w: bufio.NewWriter(nc)}
+	for {
+		args, err := readCommand(r)
+		if errors.Is(err, errProtocol) {
+			writeReply(c.w, err)
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return // the client went away
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.stats.commands.Add(1)
+
+		reply := c.handle(args)
+		writeReply(c.w, reply)
+		// pipelined commands get their replies in one write
+		if r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if strings.EqualFold(string(args[0]), "QUIT") {
+			c.w.Flush()
+			return
+		}
+	}
+}
+
+// submit queues apply for the writer and waits for its reply.
+func (s *Server) submit(apply func(tx *kv.Tx) any) any {
+	w := &write{apply: apply, reply: make(chan any, 1)}
+	select {
+	case s.queue <- w:
+	case <-s.quit:
+		return ErrServerClosed
+	}
+	return <-w.reply
+}
+
+// writer commits the queued writes in batches until Close.
+func (s *Server) writer() {
+	defer s.writerWG.Done()
+	for {
+		var batch []*write
+		select {
+		case w := <-s.queue:
+			batch = append(batch, w)
+		case <-s.quit:
+			return
+		}
+	more:
+		for len(batch) < MAX_BATCH {
+			select {
+			case w := <-s.queue:
+				batch = append(batch, w)
+			default:
+				break more
+			}
+		}
+		s.commit(batch)
+	}
+}
+
+// commit applies the batch in one transaction. Each write has its own
+// reply, failing only itself, but a commit that fails fails them all. The
+// writer is the only one writing through the server, a conflict means the
+// store was written directly and the batch is run again on top of that.
+func (s *Server) commit(batch []*write) {
+	var replies []any
+	var err error
+	for range MAX_RETRIES {
+		replies, err = s.apply(batch)
+		if !errors.Is(err, kv.ErrConflict) {
+			break
+		}
+	}
+	for i, w := range batch {
+		if err != nil {
+			w.reply <- err
+		} else {
+			w.reply <- replies[i]
+		}
+	}
+}
+
+func (s *Server) apply(batch []*write) (replies []any, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// a command that panics fails its batch rather than the server
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Abort()
+			replies, err = nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	replies = make([]any, len(batch))
+	for i, w := range batch {
+		replies[i] = w.apply(tx)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.stats.commits.Add(1)
+	return replies, nil
+}
@@ -0,0 +1,362 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/GiorgosMarga/my_db/kv"
+)
+
+// client is the bare minimum of a redis client for the tests.
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &client{t: t, c: c, r: bufio.NewReader(c)}
+}
+
+func (c *client) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.c.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends a command and returns its reply, see read.
+func (c *client) do(args ...string) any {
+	c.send(args...)
+	return c.read()
+}
+
+// read returns the next reply: a string for simple strings and bulk
+// strings, nil for the null bulk string, an int, an error or an []any.
+func (c *client) read() any {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errors.New(line[1:])
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		arr := make([]any, n)
+		for i := range arr {
+			arr[i] = c.read()
+		}
+		return arr
+	}
+	c.t.Fatalf("bad reply %q", line)
+	return nil
+}
+
+func (c *client) expect(expected any, args ...string) {
+	c.t.Helper()
+	got := c.do(args...)
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		c.t.Fatalf("%v: expected %v got %v", args, expected, got)
+	}
+}
+
+func newServer(t *testing.T) (*Server, *kv.KV, string) {
+	t.Helper()
+	db, err := kv.Open(filepath.Join(t.TempDir(), "resp.db"), &kv.Options{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db)
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve returned %v", err)
+		}
+		db.Close()
+	})
+	return s, db, l.Addr().String()
+}
+
+func TestCommands(t *testing.T) {
+	_, _, addr := newServer(t)
+	c := dial(t, addr)
+
+	c.expect("PONG", "PING")
+	c.expect("hi", "ping", "hi")
+	c.expect(nil, "GET", "a")
+	c.expect("OK", "SET", "a", "1")
+	c.expect("1", "GET", "a")
+	c.expect(nil, "SET", "a", "2", "NX")
+	c.expect("OK", "SET", "b", "2", "nx")
+	c.expect(nil, "SET", "c", "3", "XX")
+	c.expect("OK", "SET", "a", "3", "XX")
+	c.expect("3", "GET", "a")
+	c.expect("ERR syntax error", "SET", "a", "1", "NX", "XX")
+	c.expect("ERR expiration is not supported", "SET", "a", "1", "EX", "10")
+	c.expect("ERR key is too big", "SET", strings.Repeat("k", 2000), "1")
+	c.expect(3, "EXISTS", "a", "b", "c", "a")
+	c.expect(2, "DEL", "a", "c", "b", "a")
+	c.expect(0, "EXISTS", "a", "b")
+	c.expect("ERR unknown command 'NOPE'", "NOPE")
+	c.expect("ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("OK", "SELECT", "0")
+
+	// inline commands and pipelining
+	if _, err := c.c.Write([]byte("SET x 1\r\nGET x\r\nDEL x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []any{"OK", "1", 1} {
+		if got := c.read(); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatalf("pipelined: expected %v got %v", expected, got)
+		}
+	}
+
+	info, _ := c.do("INFO").(string)
+	for _, s := range []string{"# Server", "connected_clients:1", "# Storage", "total_commits:"} {
+		if !strings.Contains(info, s) {
+			t.Fatalf("INFO has no %s: %q", s, info)
+		}
+	}
+	if info, _ := c.do("INFO", "clients").(string); strings.Contains(info, "# Server") {
+		t.Fatalf("INFO clients: %q", info)
+	}
+
+	c.expect("OK", "QUIT")
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatal("the connection should be closed after QUIT")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	_, db, addr := newServer(t)
+	c := dial(t, addr)
+
+	c.expect("OK", "SET", "a", "1")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "SET", "b", "2")
+	c.expect("QUEUED", "GET", "b")
+	c.expect("QUEUED", "SET", "a", "x", "NX")
+	c.expect("QUEUED", "DEL", "a")
+	c.expect("QUEUED", "EXISTS", "a", "b")
+	// nothing is visible before EXEC
+	if _, err := db.Get([]byte("b")); !errors.Is(err, kv.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+	c.expect([]any{"OK", "2", nil, 1, 1}, "EXEC")
+	c.expect(nil, "GET", "a")
+	c.expect("2", "GET", "b")
+
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "SET", "c", "3")
+	c.expect("OK", "DISCARD")
+	c.expect(nil, "GET", "c")
+
+	c.expect("OK", "MULTI")
+	c.expect("ERR MULTI calls can not be nested", "MULTI")
+	c.expect("QUEUED", "SET", "c", "3")
+	c.expect("ERR 'scan' is not allowed inside MULTI", "SCAN", "0")
+	c.expect("EXECABORT Transaction discarded because of previous errors.", "EXEC")
+	c.expect(nil, "GET", "c")
+	c.expect("ERR EXEC without MULTI", "EXEC")
+	c.expect("ERR DISCARD without MULTI", "DISCARD")
+
+	// inline commands, typed one at a time, are queued past the reads that
+	// reuse the reader's buffer
+	for _, step := range []struct {
+		line     string
+		expected any
+	}{
+		{"MULTI", "OK"},
+		{"SET key1 value1", "QUEUED"},
+		{"SET key2 value2", "QUEUED"},
+		{"EXEC", []any{"OK", "OK"}},
+	} {
+		if _, err := c.c.Write([]byte(step.line + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.read(); fmt.Sprint(got) != fmt.Sprint(step.expected) {
+			t.Fatalf("inline %s: expected %v got %v", step.line, step.expected, got)
+		}
+	}
+	c.expect("value1", "GET", "key1")
+	c.expect("value2", "GET", "key2")
+}
+
+func TestPanic(t *testing.T) {
+	s, _, addr := newServer(t)
+	c := dial(t, addr)
+	r := s.submit(func(tx *kv.Tx) any {
+		var cmd command
+		return cmd.write(tx, nil)
+	})
+	if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "internal error") {
+		t.Fatalf("expected an internal error got %v", r)
+	}
+	// the writer is still there
+	c.expect("OK", "SET", "a", "1")
+	c.expect("1", "GET", "a")
+}
+
+func TestScan(t *testing.T) {
+	_, db, addr := newServer(t)
+	c := dial(t, addr)
+	for i := range 95 {
+		if err := db.Insert(fmt.Appendf(nil, "user:%02d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(fmt.Appendf(nil, "item:%02d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanAll := func(args ...string) []string {
+		var keys []string
+		cursor := "0"
+		for {
+			r := c.do(append([]string{"SCAN", cursor}, args...)...).([]any)
+			for _, k := range r[1].([]any) {
+				keys = append(keys, k.(string))
+			}
+			if cursor = r[0].(string); cursor == "0" {
+				return keys
+			}
+		}
+	}
+
+	if keys := scanAll(); len(keys) != 190 || !slices.IsSorted(keys) {
+		t.Fatalf("scan of everything: %d keys", len(keys))
+	}
+	if keys := scanAll("MATCH", "user:*", "COUNT", "7"); len(keys) != 95 || keys[0] != "user:00" {
+		t.Fatalf("scan of user:*: %v", keys)
+	}
+	if keys := scanAll("MATCH", "*:1?", "COUNT", "1000"); len(keys) != 20 {
+		t.Fatalf("scan of *:1?: %v", keys)
+	}
+	if keys := scanAll("TYPE", "hash"); len(keys) != 0 {
+		t.Fatalf("scan of hashes: %v", keys)
+	}
+
+	// keys added and removed during a scan
+	r := c.do("SCAN", "0", "MATCH", "user:*", "COUNT", "50").([]any)
+	cursor := r[0].(string)
+	c.expect("OK", "SET", "user:00a", "v")
+	c.expect("OK", "SET", "user:99", "v")
+	c.expect(1, "DEL", "user:94")
+	r = c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "100").([]any)
+	rest := r[1].([]any)
+	if r[0] != "0" || len(rest) != 45 || rest[len(rest)-1] != "user:99" {
+		t.Fatalf("rest of the scan: %v %v", r[0], rest)
+	}
+
+	c.expect("ERR invalid cursor", "SCAN", "12345")
+	c.expect("ERR invalid cursor", "SCAN", "x")
+	c.expect("ERR syntax error", "SCAN", "0", "MATCH")
+}
+
+func TestConcurrentClients(t *testing.T) {
+	s, db, addr := newServer(t)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := dial(t, addr)
+			for j := range 50 {
+				k := fmt.Sprintf("k_%d_%d", i, j)
+				if r := c.do("SET", k, k); r != "OK" {
+					t.Errorf("SET %s: %v", k, r)
+					return
+				}
+				if r := c.do("GET", k); r != k {
+					t.Errorf("GET %s: %v", k, r)
+					return
+				}
+			}
+			c.send("MULTI")
+			c.send("SET", fmt.Sprintf("m_%d", i), "1")
+			c.send("DEL", fmt.Sprintf("k_%d_0", i))
+			c.send("EXEC")
+			for range 3 {
+				c.read()
+			}
+			if r := c.read(); fmt.Sprint(r) != "[OK 1]" {
+				t.Errorf("EXEC: %v", r)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	it := db.Scan(nil, nil)
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	it.Close()
+	if n != 20*50 {
+		t.Fatalf("expected %d keys got %d", 20*50, n)
+	}
+	if commits := s.stats.commits.Load(); commits > 20*51 {
+		t.Fatalf("%d commits for %d writes", commits, 20*51)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s, _, addr := newServer(t)
+	c := dial(t, addr)
+	c.expect("PONG", "PING")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatal("the connection should be closed")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("the listener should be closed")
+	}
+	if err := s.Close(); err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed got %v", err)
+	}
+}